@@ -0,0 +1,147 @@
+package bencode
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Canonicalize rewrites data, which must hold a single valid Bencode value,
+// into the canonical form required by the Bencode specification: dictionary
+// keys are sorted by their raw bytes, and integers and string lengths have no
+// leading zeros, nor a negative sign on zero. It also reports whether the
+// output differs from data.
+//
+// Canonical data decodes with DecoderOptions.Strict, and is the form to use
+// when encodings are hashed. A dictionary that holds a key more than once has
+// no canonical form, so Canonicalize returns a *SyntaxError for it.
+func Canonicalize(data []byte) ([]byte, bool, error) {
+	d := decoder{
+		data:   data,
+		offset: 0,
+	}
+	var out bytes.Buffer
+	out.Grow(len(data))
+	if err := d.walk(&canonicalizer{d: &d, out: &out}); err != nil {
+		return nil, false, err
+	}
+	if !d.isDone() {
+		return nil, false, d.syntaxError(d.offset, "trailing data at offset %d cannot be parsed")
+	}
+	return out.Bytes(), !bytes.Equal(out.Bytes(), data), nil
+}
+
+// canonicalizer is a visitor that writes the canonical form of the values it
+// is given to out.
+type canonicalizer struct {
+	d   *decoder
+	out *bytes.Buffer
+
+	// dicts holds the dictionaries that enclose the current value.
+	dicts []canonicalDict
+}
+
+// canonicalDict is a dictionary being written by canonicalizer. Its entries
+// are written in input order first, and only rearranged at its end if they
+// turn out not to be sorted.
+type canonicalDict struct {
+	// regionStart is the position in the output of the first entry.
+	regionStart int
+	entries     []canonicalEntry
+	sorted      bool
+}
+
+// canonicalEntry is a dictionary entry that has been written to the output.
+type canonicalEntry struct {
+	key []byte
+
+	// offset is the position of the key in the input.
+	offset int
+
+	// start and limit delimit the entry in the output.
+	start, limit int
+}
+
+func (c *canonicalizer) Int(digits []byte) error {
+	negative := digits[0] == '-'
+	if negative {
+		digits = digits[1:]
+	}
+	digits = bytes.TrimLeft(digits, "0")
+	c.out.WriteByte(integer)
+	switch {
+	case len(digits) == 0:
+		c.out.WriteByte('0')
+	case negative:
+		c.out.WriteByte('-')
+		fallthrough
+	default:
+		c.out.Write(digits)
+	}
+	c.out.WriteByte(terminator)
+	return nil
+}
+
+func (c *canonicalizer) String(length, s []byte) error {
+	marshalBytes(s, c.out)
+	return nil
+}
+
+func (c *canonicalizer) ListStart() error {
+	c.out.WriteByte(list)
+	return nil
+}
+
+func (c *canonicalizer) ListEnd() error {
+	c.out.WriteByte(terminator)
+	return nil
+}
+
+func (c *canonicalizer) DictStart() error {
+	c.out.WriteByte(dictionary)
+	c.dicts = append(c.dicts, canonicalDict{regionStart: c.out.Len(), sorted: true})
+	return nil
+}
+
+func (c *canonicalizer) Key(length, key []byte, offset int) error {
+	dict := &c.dicts[len(c.dicts)-1]
+	if n := len(dict.entries); n > 0 {
+		dict.entries[n-1].limit = c.out.Len()
+		if bytes.Compare(dict.entries[n-1].key, key) >= 0 {
+			dict.sorted = false
+		}
+	}
+	dict.entries = append(dict.entries, canonicalEntry{key: key, offset: offset, start: c.out.Len()})
+	marshalBytes(key, c.out)
+	return nil
+}
+
+func (c *canonicalizer) DictEnd() error {
+	dict := c.dicts[len(c.dicts)-1]
+	c.dicts = c.dicts[:len(c.dicts)-1]
+	entries := dict.entries
+	if len(entries) > 0 {
+		entries[len(entries)-1].limit = c.out.Len()
+	}
+
+	if !dict.sorted {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		for i := 1; i < len(entries); i++ {
+			// The sort is stable, so entries[i] is the later occurrence of
+			// a duplicate key in the input.
+			if bytes.Equal(entries[i-1].key, entries[i].key) {
+				return c.d.syntaxError(entries[i].offset, "duplicate dictionary key at offset %d: %q", entries[i].key)
+			}
+		}
+
+		region := append([]byte{}, c.out.Bytes()[dict.regionStart:]...)
+		c.out.Truncate(dict.regionStart)
+		for _, entry := range entries {
+			c.out.Write(region[entry.start-dict.regionStart : entry.limit-dict.regionStart])
+		}
+	}
+
+	c.out.WriteByte(terminator)
+	return nil
+}
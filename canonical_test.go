@@ -0,0 +1,65 @@
+package bencode
+
+import (
+	"testing"
+)
+
+var canonicalizeTests = []struct {
+	name        string
+	in          string
+	wantErr     string
+	wantOutput  string
+	wantChanged bool
+}{
+	{name: "canonical integer", in: "i-12e", wantOutput: "i-12e"},
+	{name: "canonical dictionary", in: "d1:ai1e1:bli0e0:ee", wantOutput: "d1:ai1e1:bli0e0:ee"},
+	{name: "leading zeros in integer", in: "i007e", wantOutput: "i7e", wantChanged: true},
+	{name: "leading zeros in negative integer", in: "i-007e", wantOutput: "i-7e", wantChanged: true},
+	{name: "zero with leading zeros", in: "i000e", wantOutput: "i0e", wantChanged: true},
+	{name: "negative zero", in: "i-00e", wantOutput: "i0e", wantChanged: true},
+	{name: "leading zeros in string length", in: "003:abc", wantOutput: "3:abc", wantChanged: true},
+	{name: "empty string with leading zeros", in: "00:", wantOutput: "0:", wantChanged: true},
+	{name: "unsorted keys", in: "d1:bi2e1:ai1ee", wantOutput: "d1:ai1e1:bi2ee", wantChanged: true},
+	{name: "keys sorted by raw bytes", in: "d1:ai1e1:Bi2e2:aai3e0:i4ee", wantOutput: "d0:i4e1:Bi2e1:ai1e2:aai3ee", wantChanged: true},
+	{name: "nested values", in: "ld01:bi01e1:ad1:zi1e1:yi2eeee",
+		wantOutput: "ld1:ad1:yi2e1:zi1ee1:bi1eee", wantChanged: true},
+
+	{name: "duplicate keys", in: "d1:ai1e1:ai2ee",
+		wantErr: "duplicate dictionary key at offset 7: \"a\""},
+	{name: "unsorted duplicate keys", in: "d1:bi1e1:ai1e1:bi2ee",
+		wantErr: "duplicate dictionary key at offset 13: \"b\""},
+	{name: "duplicate keys in different spellings", in: "d1:ai1e01:ai2ee",
+		wantErr: "duplicate dictionary key at offset 7: \"a\""},
+	{name: "malformed input", in: "li1ex",
+		wantErr: "expected start of integer, string, list, or dictionary at offset 4"},
+	{name: "trailing data", in: "i1ei2e",
+		wantErr: "trailing data at offset 3 cannot be parsed"},
+	{name: "empty input", in: "",
+		wantErr: "no data to read at offset 0"},
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, testCase := range canonicalizeTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			out, changed, err := Canonicalize([]byte(testCase.in))
+			if testCase.wantErr != "" || err != nil {
+				if err == nil {
+					t.Errorf("want error with message '%v', got no error", testCase.wantErr)
+				} else if err.Error() != testCase.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+				}
+			}
+			if string(out) != testCase.wantOutput {
+				t.Errorf("got output '%s', want '%s'", out, testCase.wantOutput)
+			}
+			if changed != testCase.wantChanged {
+				t.Errorf("got changed %t, want %t", changed, testCase.wantChanged)
+			}
+			if err == nil {
+				if err := Check(out, DecoderOptions{Strict: true}); err != nil {
+					t.Errorf("got output that is not canonical: %v", err)
+				}
+			}
+		})
+	}
+}
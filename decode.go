@@ -1,7 +1,9 @@
 package bencode
 
 import (
+	"bytes"
 	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
 )
@@ -14,81 +16,377 @@ const (
 )
 
 // Unmarshal deserializes a Bencode string.
+//
+// To unmarshal into an empty interface value, Unmarshal stores one of the
+// following in the interface, mirroring encoding/json:
+//
+//	int64, for Bencode integers
+//	string, for Bencode strings
+//	[]interface{}, for Bencode lists
+//	map[string]interface{}, for Bencode dictionaries
+//
+// If the interface already holds a non-nil pointer, Unmarshal decodes into
+// the value it points to instead.
+//
+// If data is not a single valid Bencode value, or cannot be stored in v,
+// Unmarshal returns an error and leaves v unmodified.
 func Unmarshal(data []byte, v interface{}) error {
-	// TODO: Don't modify the interface until we know the full output is valid.
+	return DecoderOptions{}.Unmarshal(data, v)
+}
+
+// DecoderOptions configures how Bencode input is decoded. The zero value
+// gives the default behavior of Unmarshal.
+type DecoderOptions struct {
+	// Strict rejects input that is not in the canonical form required by
+	// the Bencode specification: integers and string lengths with leading
+	// zeros, negative zero, and dictionaries whose keys are not sorted by
+	// their raw bytes or appear more than once. Each Bencode value has a
+	// single canonical encoding, which matters wherever encodings are
+	// hashed, such as for the info dictionary of a torrent file.
+	Strict bool
+
+	// MaxDepth limits how deeply lists and dictionaries may be nested. Zero
+	// means DefaultMaxDepth, and a negative value means no limit. The limit
+	// guards against input that would otherwise exhaust the stack.
+	MaxDepth int
+
+	// MaxStringLength limits the length of each string, including
+	// dictionary keys. Zero means no limit.
+	MaxStringLength int
+
+	// MaxElements limits the total number of values that appear in lists
+	// and dictionaries across the input. Each dictionary entry counts as
+	// two values, its key and its value. Zero means no limit.
+	MaxElements int
+
+	// MaxInputSize limits the size of the input in bytes. For a Decoder, it
+	// limits the size of each value read from the stream. Zero means no
+	// limit.
+	MaxInputSize int
+}
 
+// DefaultMaxDepth is the nesting depth permitted when DecoderOptions.MaxDepth
+// is zero.
+const DefaultMaxDepth = 10000
+
+func (o DecoderOptions) maxDepth() int {
+	switch {
+	case o.MaxDepth == 0:
+		return DefaultMaxDepth
+	case o.MaxDepth < 0:
+		return maxInt
+	}
+	return o.MaxDepth
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// Unmarshal is like the package-level Unmarshal, but decodes according to
+// the options in o.
+func (o DecoderOptions) Unmarshal(data []byte, v interface{}) error {
 	value := reflect.ValueOf(v)
 	if value.Kind() != reflect.Ptr || value.IsNil() {
-		return fmt.Errorf("v is not a non-nil pointer: %s", reflect.TypeOf((v)))
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
 	}
+	return unmarshal(data, 0, &value, o)
+}
+
+// Valid reports whether data is a single well-formed Bencode value, nested no
+// more deeply than DefaultMaxDepth.
+func Valid(data []byte) bool {
+	return Check(data, DecoderOptions{}) == nil
+}
 
-	// First run through the input using a no-op valueSetter. This allows us
-	// to report an error if the input in malformed without making any partial
-	// modifications to the output parameter v.
-	validator := decoder{
-		data:        data,
-		offset:      0,
-		valueSetter: noOpValueSetter{},
+// Check reports the first problem that prevents data from being decoded with
+// the given options, without decoding it into a Go value. It returns nil if
+// data is a single valid Bencode value that is within the limits of opts,
+// and in canonical form if opts.Strict is set. Otherwise, the error is a
+// *SyntaxError or a *LimitError.
+func Check(data []byte, opts DecoderOptions) error {
+	return unmarshal(data, 0, nil, opts)
+}
+
+// unmarshal decodes data into value, which must be a non-nil pointer, or nil
+// to only check data. base is the position of data within the overall input
+// and is used only for reporting errors.
+//
+// The input is decoded in a single pass, writing to the output as it goes. If
+// the input turns out to be invalid, every modification is undone, so that
+// the output is left exactly as it was found.
+func unmarshal(data []byte, base int, value *reflect.Value, opts DecoderOptions) error {
+	decoder := decoder{
+		data:   data,
+		offset: 0,
+		base:   base,
+		opts:   opts,
+	}
+	if opts.MaxInputSize > 0 && len(data) > opts.MaxInputSize {
+		return limitError(ErrMaxInputSize, opts.MaxInputSize, base+opts.MaxInputSize)
+	}
+	err := decoder.unmarshalNext(value)
+	if err == nil && !decoder.isDone() {
+		err = decoder.syntaxError(decoder.offset, "trailing data at offset %d cannot be parsed")
 	}
-	err := validator.unmarshalNext(&value)
 	if err != nil {
+		decoder.valueSetter.rollback()
 		return err
 	}
-	if !validator.isDone() {
-		return fmt.Errorf("trailing data at offset %d cannot be parsed", validator.offset)
-	}
+	return nil
+}
 
-	// The input is valid, so now we do our second pass over the input and
-	// fill the output parameter.
-	decoder := decoder{
-		data:        data,
-		offset:      0,
-		valueSetter: valueSetter{},
-	}
-	return decoder.unmarshalNext(&value)
+// checkValid returns an error if data is not a single well-formed Bencode
+// value. It is used on encoder output, which may be nested arbitrarily
+// deeply.
+func checkValid(data []byte) error {
+	return Check(data, DecoderOptions{MaxDepth: -1})
 }
 
-// valueSetterInterface abstracts a subset of the reflect.Value modifiers.
-type valueSetterInterface interface {
-	SetInt(value *reflect.Value, i int64)
-	SetString(value *reflect.Value, s string)
-	Append(target *reflect.Value, elem reflect.Value)
+// valueSetter wraps the reflect.Value modifiers used to fill the output. It
+// journals the previous state of everything it modifies, so that a failed
+// decoding can be rolled back.
+//
+// Values allocated by the decoder itself, such as list elements, are not
+// reachable from the output until they are linked into it, and so need no
+// journaling while they are filled. The fresh flag is set while decoding into
+// such values.
+type valueSetter struct {
+	journal []journalEntry
+	fresh   bool
 }
 
-// valueSetter delegates directly to the reflect.Value modifiers.
-type valueSetter struct{}
+// journalEntry records the state of part of the output before it was
+// modified.
+type journalEntry struct {
+	// target is the modified value, or the map for modified map entries.
+	target reflect.Value
 
-func (valueSetter) SetInt(value *reflect.Value, i int64) {
+	// old is the previous value of target, or of the map entry. For map
+	// entries that did not exist, it is the zero Value.
+	old reflect.Value
+
+	// key is the key of the modified map entry, if any.
+	key reflect.Value
+}
+
+// record journals the current value of target, unless target is fresh.
+func (s *valueSetter) record(target reflect.Value) {
+	if s.fresh {
+		return
+	}
+	old := reflect.New(target.Type()).Elem()
+	old.Set(target)
+	s.journal = append(s.journal, journalEntry{target: target, old: old})
+}
+
+// rollback undoes every journaled modification, most recent first.
+func (s *valueSetter) rollback() {
+	for i := len(s.journal) - 1; i >= 0; i-- {
+		entry := s.journal[i]
+		if entry.key.IsValid() {
+			entry.target.SetMapIndex(entry.key, entry.old)
+		} else {
+			entry.target.Set(entry.old)
+		}
+	}
+	s.journal = nil
+}
+
+func (s *valueSetter) SetInt(value *reflect.Value, i int64) {
+	s.record(value.Elem())
 	value.Elem().SetInt(i)
 }
-func (valueSetter) SetString(value *reflect.Value, s string) {
-	value.Elem().SetString(s)
+func (s *valueSetter) SetUint(value *reflect.Value, u uint64) {
+	s.record(value.Elem())
+	value.Elem().SetUint(u)
+}
+func (s *valueSetter) SetString(value *reflect.Value, str string) {
+	s.record(value.Elem())
+	value.Elem().SetString(str)
 }
-func (valueSetter) Append(target *reflect.Value, elem reflect.Value) {
+func (s *valueSetter) SetBytes(value *reflect.Value, b []byte) {
+	s.record(value.Elem())
+	if value.Elem().Kind() == reflect.Array {
+		// Set each element, as reflect.Copy requires the element type to be
+		// exactly uint8 rather than any type of that kind.
+		for i, c := range b {
+			value.Elem().Index(i).SetUint(uint64(c))
+		}
+		return
+	}
+	// Copy b, since it aliases the input.
+	value.Elem().SetBytes(append([]byte{}, b...))
+}
+func (s *valueSetter) Set(target *reflect.Value, elem reflect.Value) {
+	s.record(target.Elem())
+	target.Elem().Set(elem)
+}
+
+// Save journals the slice that target points to ahead of a series of calls to
+// Append, which are not journaled individually.
+func (s *valueSetter) Save(target *reflect.Value) {
+	s.record(target.Elem())
+}
+func (s *valueSetter) Append(target *reflect.Value, elem reflect.Value) {
 	target.Elem().Set(reflect.Append(target.Elem(), reflect.Indirect(elem)))
 }
+func (s *valueSetter) MakeMap(target *reflect.Value) {
+	s.Set(target, reflect.MakeMap(target.Elem().Type()))
+}
+
+// SetMapIndex stores elem in the map that target points to. newMap reports
+// whether the map was created by MakeMap during this decoding, in which case
+// its entries need no journaling.
+func (s *valueSetter) SetMapIndex(target *reflect.Value, key string, elem reflect.Value, newMap bool) {
+	m := target.Elem()
+	mapKey := reflect.ValueOf(key).Convert(m.Type().Key())
+	if !s.fresh && !newMap {
+		s.journal = append(s.journal, journalEntry{target: m, old: m.MapIndex(mapKey), key: mapKey})
+	}
+	m.SetMapIndex(mapKey, reflect.Indirect(elem))
+}
 
-// noOpValueSetter is a valueSetterInterface that does nothing. This is useful
-// during the validation phase of deserialization.
-type noOpValueSetter struct{}
+// Unmarshal runs the Unmarshaler that target, or the pointer it points to,
+// implements. Unless target is fresh, the Unmarshaler runs on a copy of the
+// existing value, which replaces the original only if it succeeds.
+func (s *valueSetter) Unmarshal(target *reflect.Value, data []byte) error {
+	ptr := *target
+	if !ptr.Type().Implements(unmarshalerType) {
+		if ptr.Elem().IsNil() {
+			elem := reflect.New(ptr.Elem().Type().Elem())
+			if err := elem.Interface().(Unmarshaler).UnmarshalBencode(data); err != nil {
+				return err
+			}
+			s.Set(target, elem)
+			return nil
+		}
+		ptr = ptr.Elem()
+	}
 
-func (noOpValueSetter) SetInt(value *reflect.Value, i int64)             {}
-func (noOpValueSetter) SetString(value *reflect.Value, s string)         {}
-func (noOpValueSetter) Append(target *reflect.Value, elem reflect.Value) {}
+	if s.fresh {
+		return ptr.Interface().(Unmarshaler).UnmarshalBencode(data)
+	}
+	elem := reflect.New(ptr.Type().Elem())
+	elem.Elem().Set(ptr.Elem())
+	if err := elem.Interface().(Unmarshaler).UnmarshalBencode(data); err != nil {
+		return err
+	}
+	s.Set(&ptr, elem.Elem())
+	return nil
+}
+
+// Indirect returns the pointer that value points to, allocating it first if
+// it is nil. It also reports whether the pointer was allocated, in which case
+// the value it points to is fresh.
+func (s *valueSetter) Indirect(value *reflect.Value) (reflect.Value, bool) {
+	if value.Elem().IsNil() {
+		s.Set(value, reflect.New(value.Elem().Type().Elem()))
+		return value.Elem(), true
+	}
+	return value.Elem(), false
+}
 
 type decoder struct {
 	data        []byte
 	offset      int
-	valueSetter valueSetterInterface
+	valueSetter valueSetter
+
+	// base is the position of data[0] within the overall input. It is
+	// non-zero only when decoding a value read from a stream, and is added
+	// to every offset reported in an error.
+	base int
+
+	opts DecoderOptions
+
+	// path leads from the top-level value to the one being decoded. It is
+	// only maintained while decoding into a Go value, and is reported in
+	// type errors.
+	path []pathElem
+
+	// depth is the number of lists and dictionaries that enclose the
+	// current offset, and elements is the number of values found in them so
+	// far. Both are checked against the limits in opts.
+	depth    int
+	elements int
 }
 
 func (d *decoder) isDone() bool {
 	return len(d.data) <= d.offset
 }
 
+// syntaxError returns a *SyntaxError for the given offset within data. The
+// offset, adjusted by base, is the first argument for format.
+func (d *decoder) syntaxError(offset int, format string, args ...interface{}) error {
+	args = append([]interface{}{d.base + offset}, args...)
+	return &SyntaxError{msg: fmt.Sprintf(format, args...), Offset: d.base + offset}
+}
+
+// enterContainer records that a list or dictionary starts at the current
+// offset, enforcing the maximum depth. Every call must be matched by a call
+// to exitContainer once the container ends.
+func (d *decoder) enterContainer() error {
+	d.depth++
+	if max := d.opts.maxDepth(); d.depth > max {
+		return limitError(ErrMaxDepth, max, d.base+d.offset)
+	}
+	return nil
+}
+
+func (d *decoder) exitContainer() {
+	d.depth--
+}
+
+// countElement records that a value inside a list or dictionary starts at
+// the current offset, enforcing the maximum number of elements.
+func (d *decoder) countElement() error {
+	d.elements++
+	if max := d.opts.MaxElements; max > 0 && d.elements > max {
+		return limitError(ErrMaxElements, max, d.base+d.offset)
+	}
+	return nil
+}
+
+func limitError(err error, limit, offset int) error {
+	return &LimitError{Err: err, Limit: limit, Offset: offset}
+}
+
+// typeError returns an *UnmarshalTypeError for the value at the current
+// offset.
+func (d *decoder) typeError(what string, t reflect.Type) error {
+	return &UnmarshalTypeError{Value: what, Type: t, Offset: d.base + d.offset, Field: fieldPath(d.path)}
+}
+
 func (d *decoder) unmarshalNext(value *reflect.Value) error {
-	if len(d.data) == 0 {
-		return fmt.Errorf("no data to read at offset %d", d.offset)
+	if d.isDone() {
+		return d.syntaxError(d.offset, "no data to read at offset %d")
+	}
+
+	if value != nil && value.CanInterface() && implementsUnmarshaler(value) {
+		return d.unmarshalCustom(value)
+	}
+
+	// Follow pointers, allocating them as needed. *big.Int is the exception,
+	// as it is decoded as a whole.
+	if value != nil && value.Elem().Kind() == reflect.Ptr && value.Elem().Type() != bigIntPtrType {
+		elem, allocated := d.valueSetter.Indirect(value)
+		if allocated {
+			return d.unmarshalFresh(&elem)
+		}
+		return d.unmarshalNext(&elem)
+	}
+
+	if value != nil && value.Elem().Kind() == reflect.Interface {
+		// Like encoding/json, decode into the value an interface points to
+		// rather than replacing it.
+		if elem := value.Elem().Elem(); elem.Kind() == reflect.Ptr && !elem.IsNil() {
+			return d.unmarshalNext(&elem)
+		}
+		if value.Elem().NumMethod() == 0 {
+			return d.unmarshalInterface(value)
+		}
+	}
+
+	if value != nil && value.Elem().Type() == bencodeValueType {
+		return d.unmarshalValue(value)
 	}
 
 	if isDigit(d.data[d.offset]) {
@@ -103,7 +401,86 @@ func (d *decoder) unmarshalNext(value *reflect.Value) error {
 	case dictionary:
 		return d.unmarshalDict(value)
 	}
-	return fmt.Errorf("expected start of integer, string, list, or dictionary at offset %d", d.offset)
+	return d.syntaxError(d.offset, "expected start of integer, string, list, or dictionary at offset %d")
+}
+
+// unmarshalFresh decodes the next value into value, which was allocated by the
+// decoder and is not yet reachable from the output.
+func (d *decoder) unmarshalFresh(value *reflect.Value) error {
+	fresh := d.valueSetter.fresh
+	d.valueSetter.fresh = true
+	err := d.unmarshalNext(value)
+	d.valueSetter.fresh = fresh
+	return err
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal a
+// Bencode description of themselves. The input is a single, well-formed
+// Bencode value. UnmarshalBencode must copy the data if it wishes to retain
+// it after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// implementsUnmarshaler reports whether the value that value points to should
+// be decoded with an Unmarshaler: either value itself implements it, or
+// value points to a pointer that does.
+func implementsUnmarshaler(value *reflect.Value) bool {
+	if t := value.Elem().Type(); t == bencodeValueType || t == bencodeValuePtrType {
+		// Values are decoded by unmarshalValue instead.
+		return false
+	}
+	return value.Type().Implements(unmarshalerType) ||
+		value.Elem().Kind() == reflect.Ptr && value.Elem().Type().Implements(unmarshalerType)
+}
+
+// unmarshalCustom hands the raw bytes of the next value to an Unmarshaler.
+func (d *decoder) unmarshalCustom(value *reflect.Value) error {
+	start := d.offset
+	if err := d.unmarshalNext(nil); err != nil {
+		return err
+	}
+	if err := d.valueSetter.Unmarshal(value, d.data[start:d.offset]); err != nil {
+		return fmt.Errorf("error calling UnmarshalBencode for %s at offset %d: %w", value.Elem().Type(), d.base+start, err)
+	}
+	return nil
+}
+
+var (
+	genericIntType  = reflect.TypeOf(int64(0))
+	genericStrType  = reflect.TypeOf("")
+	genericListType = reflect.TypeOf([]interface{}{})
+	genericDictType = reflect.TypeOf(map[string]interface{}{})
+)
+
+// unmarshalInterface decodes the next value into an empty interface. The
+// concrete type stored in the interface is chosen based on the input:
+// integers become int64, strings become string, lists become []interface{},
+// and dictionaries become map[string]interface{}.
+func (d *decoder) unmarshalInterface(value *reflect.Value) error {
+	var elem reflect.Value
+	switch c := d.data[d.offset]; {
+	case isDigit(c):
+		elem = reflect.New(genericStrType)
+	case c == integer:
+		elem = reflect.New(genericIntType)
+	case c == list:
+		elem = reflect.New(genericListType)
+		elem.Elem().Set(reflect.MakeSlice(genericListType, 0, 0))
+	case c == dictionary:
+		elem = reflect.New(genericDictType)
+	default:
+		// Let the usual dispatch report the malformed input.
+		return d.unmarshalNext(nil)
+	}
+
+	if err := d.unmarshalFresh(&elem); err != nil {
+		return err
+	}
+	d.valueSetter.Set(value, elem.Elem())
+	return nil
 }
 
 func isDigit(b byte) bool {
@@ -117,35 +494,52 @@ func intLimit(offset int, data []byte) int {
 	return offset
 }
 
-func stringIndices(offset int, data []byte) (int, int, error) {
+func (d *decoder) stringIndices(offset int) (int, int, error) {
 	intStart := offset
-	intLimit := intLimit(intStart, data)
-	length, err := strconv.Atoi(string(data[intStart:intLimit]))
+	intLimit := intLimit(intStart, d.data)
+	length, err := strconv.Atoi(string(d.data[intStart:intLimit]))
 	if err != nil {
-		return 0, 0, fmt.Errorf("could not parse length for string at offset %d", offset)
+		return 0, 0, d.syntaxError(offset, "could not parse length for string at offset %d")
 	}
-	if intLimit >= len(data) || data[intLimit] != ':' {
-		return 0, 0, fmt.Errorf("expected colon between length and value for string at offset %d", offset)
+	if d.opts.Strict && d.data[intStart] == '0' && intLimit-intStart > 1 {
+		return 0, 0, d.syntaxError(offset, "length for string at offset %d has a leading zero")
+	}
+	if intLimit >= len(d.data) || d.data[intLimit] != ':' {
+		return 0, 0, d.syntaxError(offset, "expected colon between length and value for string at offset %d")
+	}
+	if max := d.opts.MaxStringLength; max > 0 && length > max {
+		return 0, 0, limitError(ErrMaxStringLength, max, d.base+offset)
 	}
 	strStart := intLimit + 1
-	strLimit := strStart + length
-	if strLimit > len(data) {
-		return 0, 0, fmt.Errorf("string at offset %d has length %d, yet there are not that many bytes left", offset, length)
+	// Compare against the bytes left rather than adding length to strStart,
+	// which can overflow for huge lengths.
+	if length > len(d.data)-strStart {
+		return 0, 0, d.syntaxError(offset, "string at offset %d has length %d, yet there are not that many bytes left", length)
 	}
-	return strStart, strLimit, nil
+	return strStart, strStart + length, nil
 }
 
 func (d *decoder) unmarshalString(value *reflect.Value) error {
-	start, limit, err := stringIndices(d.offset, d.data)
+	start, limit, err := d.stringIndices(d.offset)
 	if err != nil {
 		return err
 	}
 
 	if value != nil {
-		if value.Elem().Type().Kind() != reflect.String {
-			return fmt.Errorf("cannot unmarshal string at offset %d into %s", d.offset, value.Elem().Type())
+		valueType := value.Elem().Type()
+		switch {
+		case valueType.Kind() == reflect.String:
+			d.valueSetter.SetString(value, string(d.data[start:limit]))
+		case valueType.Kind() == reflect.Slice && valueType.Elem().Kind() == reflect.Uint8:
+			d.valueSetter.SetBytes(value, d.data[start:limit])
+		case valueType.Kind() == reflect.Array && valueType.Elem().Kind() == reflect.Uint8:
+			if valueType.Len() != limit-start {
+				return d.typeError("string of length "+strconv.Itoa(limit-start), valueType)
+			}
+			d.valueSetter.SetBytes(value, d.data[start:limit])
+		default:
+			return d.typeError("string", valueType)
 		}
-		d.valueSetter.SetString(value, string(d.data[start:limit]))
 	}
 	d.offset = limit
 	return nil
@@ -153,35 +547,95 @@ func (d *decoder) unmarshalString(value *reflect.Value) error {
 
 func (d *decoder) unmarshalInt(value *reflect.Value) error {
 	intStart := d.offset + 1
-	intLimit := intLimit(intStart+1, d.data) // First character may be a '-'.
-
-	i, err := strconv.Atoi(string(d.data[intStart:intLimit]))
-	if err != nil {
-		return fmt.Errorf("expected integer at offset %d", intStart)
+	digitStart := intStart
+	if digitStart < len(d.data) && d.data[digitStart] == '-' {
+		digitStart++
+	}
+	intLimit := intLimit(digitStart, d.data)
+	if intLimit == digitStart {
+		return d.syntaxError(intStart, "expected integer at offset %d")
 	}
 
 	if intLimit >= len(d.data) || d.data[intLimit] != terminator {
-		return fmt.Errorf("expected terminator for integer at offset %d", intLimit)
+		return d.syntaxError(intLimit, "expected terminator for integer at offset %d")
 	}
 
+	if d.opts.Strict && d.data[digitStart] == '0' {
+		if intLimit-digitStart > 1 {
+			return d.syntaxError(intStart, "integer at offset %d has a leading zero")
+		}
+		if digitStart != intStart {
+			return d.syntaxError(intStart, "integer at offset %d is negative zero")
+		}
+	}
+
+	// The integer is only converted when there is a destination for it, so
+	// that values of any magnitude can be skipped.
 	if value != nil {
-		if value.Elem().Type().Kind() != reflect.Int64 {
-			return fmt.Errorf("cannot unmarshal integer at offset %d into %s", d.offset, value.Elem().Type())
+		if err := d.setInt(value, string(d.data[intStart:intLimit])); err != nil {
+			return err
 		}
-		d.valueSetter.SetInt(value, int64(i))
 	}
 	d.offset = intLimit + 1
 	return nil
 }
 
+// setInt stores the decimal integer s, which starts at the current offset,
+// in the integer that value points to.
+func (d *decoder) setInt(value *reflect.Value, s string) error {
+	valueType := value.Elem().Type()
+	if valueType == bigIntType || valueType == bigIntPtrType {
+		// Always store a new big.Int rather than updating the existing one,
+		// whose internal buffer might be shared with other values.
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return d.syntaxError(d.offset+1, "expected integer at offset %d")
+		}
+		if valueType == bigIntType {
+			d.valueSetter.Set(value, reflect.ValueOf(i).Elem())
+		} else {
+			d.valueSetter.Set(value, reflect.ValueOf(i))
+		}
+		return nil
+	}
+
+	switch valueType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || value.Elem().OverflowInt(i) {
+			return d.typeError("integer "+s, valueType)
+		}
+		d.valueSetter.SetInt(value, i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || value.Elem().OverflowUint(u) {
+			return d.typeError("integer "+s, valueType)
+		}
+		d.valueSetter.SetUint(value, u)
+	default:
+		return d.typeError("integer", valueType)
+	}
+	return nil
+}
+
 func (d *decoder) unmarshalList(value *reflect.Value) error {
 	if value != nil && value.Elem().Type().Kind() != reflect.Slice {
-		return fmt.Errorf("cannot unmarshal list at offset %d into %s", d.offset, value.Elem().Type())
+		return d.typeError("list", value.Elem().Type())
 	}
 
+	if err := d.enterContainer(); err != nil {
+		return err
+	}
 	d.offset++ // Consume 'l'.
 
+	if value != nil {
+		d.valueSetter.Save(value)
+		d.path = append(d.path, pathElem{index: 0})
+	}
 	for d.offset < len(d.data) && d.data[d.offset] != terminator {
+		if err := d.countElement(); err != nil {
+			return err
+		}
 		if value == nil {
 			if err := d.unmarshalNext(nil); err != nil {
 				return err
@@ -190,53 +644,112 @@ func (d *decoder) unmarshalList(value *reflect.Value) error {
 		}
 
 		elem := reflect.New(value.Elem().Type().Elem())
-		if err := d.unmarshalNext(&elem); err != nil {
+		if err := d.unmarshalFresh(&elem); err != nil {
 			return err
 		}
 		d.valueSetter.Append(value, elem)
+		d.path[len(d.path)-1].index++
+	}
+	if value != nil {
+		d.path = d.path[:len(d.path)-1]
 	}
 
 	if d.offset >= len(d.data) || d.data[d.offset] != terminator {
-		return fmt.Errorf("expected terminator for list at offset %d", d.offset)
+		return d.syntaxError(d.offset, "expected terminator for list at offset %d")
 	}
 	d.offset++
+	d.exitContainer()
 	return nil
 }
 
-func (d *decoder) unmarshalDict(value *reflect.Value) error {
-	if value != nil && value.Elem().Type().Kind() != reflect.Struct {
-		return fmt.Errorf("cannot unmarshal dictionary at offset %d into %s", d.offset, value.Elem().Type())
+// fieldByIndex returns a pointer to the field with the given index sequence
+// in the struct that value points to. Nil embedded pointers along the way are
+// allocated, so this is only done once the field is known to be present.
+func (d *decoder) fieldByIndex(value *reflect.Value, index []int) (reflect.Value, error) {
+	v := value.Elem()
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() && !v.CanSet() {
+				return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s at offset %d", v.Type().Elem(), d.base+d.offset)
+			}
+			ptr := v.Addr()
+			v, _ = d.valueSetter.Indirect(&ptr)
+			v = v.Elem()
+		}
+		v = v.Field(x)
 	}
+	return v.Addr(), nil
+}
 
-	structValues := make(map[string]reflect.Value)
+func (d *decoder) unmarshalDict(value *reflect.Value) error {
+	var isMap, newMap bool
+	var plan *structPlan
 	if value != nil {
-		structType := value.Elem().Type()
-		for i := 0; i < structType.NumField(); i++ {
-			field := structType.Field(i)
-			key, ok := field.Tag.Lookup("bencode")
-			if !ok {
-				continue
+		valueType := value.Elem().Type()
+		switch {
+		case valueType.Kind() == reflect.Struct:
+			plan = cachedStructPlan(valueType)
+		case valueType.Kind() == reflect.Map && valueType.Key().Kind() == reflect.String:
+			isMap = true
+			if value.Elem().IsNil() {
+				d.valueSetter.MakeMap(value)
+				newMap = true
 			}
-			structValues[key] = value.Elem().Field(i).Addr()
+		default:
+			return d.typeError("dictionary", valueType)
 		}
 	}
 
+	if err := d.enterContainer(); err != nil {
+		return err
+	}
 	d.offset++ // Consume 'd'.
+	if value != nil {
+		d.path = append(d.path, pathElem{index: -1})
+	}
+	var prevKey []byte
 	for d.offset < len(d.data) && d.data[d.offset] != terminator {
+		if err := d.countElement(); err != nil {
+			return err
+		}
 		if !isDigit(d.data[d.offset]) {
-			return fmt.Errorf("dictionary key at offset %d is not a string", d.offset)
+			return d.syntaxError(d.offset, "dictionary key at offset %d is not a string")
 		}
-		start, limit, err := stringIndices(d.offset, d.data)
+		start, limit, err := d.stringIndices(d.offset)
 		if err != nil {
 			return err
 		}
-		key := string(d.data[start:limit])
+		if d.opts.Strict {
+			if err := d.checkKeyOrder(prevKey, d.data[start:limit]); err != nil {
+				return err
+			}
+			prevKey = d.data[start:limit]
+		}
+		key := d.data[start:limit]
 		d.offset = limit
+		if value != nil {
+			d.path[len(d.path)-1].key = key
+		}
+		if err := d.countElement(); err != nil {
+			return err
+		}
+
+		if isMap {
+			elem := reflect.New(value.Elem().Type().Elem())
+			if err := d.unmarshalFresh(&elem); err != nil {
+				return err
+			}
+			d.valueSetter.SetMapIndex(value, string(key), elem, newMap)
+			continue
+		}
 
 		var nextValue *reflect.Value
-		value, ok := structValues[key]
-		if ok {
-			nextValue = &value
+		if f, ok := plan.field(string(key)); ok {
+			fieldValue, err := d.fieldByIndex(value, f.index)
+			if err != nil {
+				return err
+			}
+			nextValue = &fieldValue
 		}
 
 		if err := d.unmarshalNext(nextValue); err != nil {
@@ -245,8 +758,28 @@ func (d *decoder) unmarshalDict(value *reflect.Value) error {
 	}
 
 	if d.offset >= len(d.data) || d.data[d.offset] != terminator {
-		return fmt.Errorf("expected terminator for dictionary at offset %d", d.offset)
+		return d.syntaxError(d.offset, "expected terminator for dictionary at offset %d")
 	}
 	d.offset++
+	d.exitContainer()
+	if value != nil {
+		d.path = d.path[:len(d.path)-1]
+	}
+	return nil
+}
+
+// checkKeyOrder returns an error unless key, which starts at the current
+// offset, sorts strictly after the previous key in the same dictionary. prev
+// is nil for the first key.
+func (d *decoder) checkKeyOrder(prev, key []byte) error {
+	if prev == nil {
+		return nil
+	}
+	switch bytes.Compare(prev, key) {
+	case 0:
+		return d.syntaxError(d.offset, "duplicate dictionary key at offset %d: %q", key)
+	case 1:
+		return d.syntaxError(d.offset, "dictionary key at offset %d is not sorted after the previous key: %q", key)
+	}
 	return nil
 }
@@ -1,8 +1,14 @@
 package bencode
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type simpleStruct struct {
@@ -12,6 +18,59 @@ type simpleStruct struct {
 	Unnamed string
 }
 
+type stringKind string
+
+type byteKind byte
+
+// commaList unmarshals itself from a Bencode string holding comma-separated
+// values.
+type commaList []string
+
+func (l *commaList) UnmarshalBencode(data []byte) error {
+	var s string
+	if err := Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*l = strings.Split(s, ",")
+	return nil
+}
+
+// rawCapture records the raw bytes it is unmarshaled from.
+type rawCapture struct {
+	raw string
+}
+
+func (c *rawCapture) UnmarshalBencode(data []byte) error {
+	c.raw = string(data)
+	return nil
+}
+
+type unmarshalerStruct struct {
+	List    commaList   `bencode:"list"`
+	Pointer *rawCapture `bencode:"pointer"`
+	Value   rawCapture  `bencode:"value"`
+}
+
+type mapStruct struct {
+	Files map[string]int64 `bencode:"files"`
+}
+
+type pointerStruct struct {
+	Int    *int64           `bencode:"int"`
+	Slice  *[]string        `bencode:"slice"`
+	Struct *simpleStruct    `bencode:"struct"`
+	Nested **compositStruct `bencode:"nested"`
+}
+
+type tagOptionsStruct struct {
+	Untagged   string
+	Ignored    string `bencode:"-"`
+	Dash       string `bencode:"-,"`
+	OmitEmpty  string `bencode:"omit,omitempty"`
+	NoKey      string `bencode:",omitempty"`
+	unexported string `bencode:"unexported"`
+}
+
 type compositStruct struct {
 	StringList []string       `bencode:"strings"`
 	IntList    []int64        `bencode:"ints"`
@@ -32,7 +91,64 @@ var decodeTests = []struct {
 	{name: "zero integer", in: "i0e", outputArg: int64(0), wantOutput: int64(0)},
 	{name: "positive integer", in: "i651e", outputArg: int64(0), wantOutput: int64(651)},
 	{name: "negative integer", in: "i-601e", outputArg: int64(0), wantOutput: int64(-601)},
+	{name: "plus sign", in: "i+1e", outputArg: int64(0),
+		wantOutput: int64(0),
+		wantErr:    "expected integer at offset 1"},
+
+	{name: "int", in: "i-651e", outputArg: int(0), wantOutput: int(-651)},
+	{name: "int8", in: "i-128e", outputArg: int8(0), wantOutput: int8(-128)},
+	{name: "int16", in: "i32767e", outputArg: int16(0), wantOutput: int16(32767)},
+	{name: "int32", in: "i-2147483648e", outputArg: int32(0), wantOutput: int32(-2147483648)},
+	{name: "max int64", in: "i9223372036854775807e", outputArg: int64(0), wantOutput: int64(math.MaxInt64)},
+	{name: "min int64", in: "i-9223372036854775808e", outputArg: int64(0), wantOutput: int64(math.MinInt64)},
+	{name: "uint", in: "i651e", outputArg: uint(0), wantOutput: uint(651)},
+	{name: "uint8", in: "i255e", outputArg: uint8(0), wantOutput: uint8(255)},
+	{name: "uint16", in: "i65535e", outputArg: uint16(0), wantOutput: uint16(65535)},
+	{name: "uint32", in: "i4294967295e", outputArg: uint32(0), wantOutput: uint32(4294967295)},
+	{name: "max uint64", in: "i18446744073709551615e", outputArg: uint64(0), wantOutput: uint64(math.MaxUint64)},
+	{name: "named integer type", in: "i3e", outputArg: time.Duration(0), wantOutput: time.Duration(3)},
+	{name: "mixed integer fields", in: "d1:ai-1e1:bi2e1:ci300ee",
+		outputArg: struct {
+			A int8   `bencode:"a"`
+			B uint16 `bencode:"b"`
+			C int    `bencode:"c"`
+		}{},
+		wantOutput: struct {
+			A int8   `bencode:"a"`
+			B uint16 `bencode:"b"`
+			C int    `bencode:"c"`
+		}{A: -1, B: 2, C: 300}},
+
+	{name: "int8 overflow", in: "i128e", outputArg: int8(0),
+		wantOutput: int8(0),
+		wantErr:    "cannot unmarshal integer 128 at offset 0 into int8"},
+	{name: "int8 underflow", in: "i-129e", outputArg: int8(0),
+		wantOutput: int8(0),
+		wantErr:    "cannot unmarshal integer -129 at offset 0 into int8"},
+	{name: "int64 overflow", in: "i9223372036854775808e", outputArg: int64(0),
+		wantOutput: int64(0),
+		wantErr:    "cannot unmarshal integer 9223372036854775808 at offset 0 into int64"},
+	{name: "uint8 overflow", in: "i256e", outputArg: uint8(0),
+		wantOutput: uint8(0),
+		wantErr:    "cannot unmarshal integer 256 at offset 0 into uint8"},
+	{name: "uint64 overflow", in: "i18446744073709551616e", outputArg: uint64(0),
+		wantOutput: uint64(0),
+		wantErr:    "cannot unmarshal integer 18446744073709551616 at offset 0 into uint64"},
+	{name: "negative unsigned", in: "i-1e", outputArg: uint(0),
+		wantOutput: uint(0),
+		wantErr:    "cannot unmarshal integer -1 at offset 0 into uint"},
+	{name: "overflow in list", in: "li1ei1000ee", outputArg: []uint8{},
+		wantOutput: *new([]uint8),
+		wantErr:    "cannot unmarshal integer 1000 at offset 4 into field [1] of type uint8"},
+	{name: "generic integer overflow", in: "i9223372036854775808e", outputArg: (*interface{})(nil),
+		wantOutput: nil,
+		wantErr:    "cannot unmarshal integer 9223372036854775808 at offset 0 into int64"},
+	{name: "skipped large integer", in: "d1:xi1e5:largei123456789012345678901234567890ee", outputArg: simpleStruct{},
+		wantOutput: simpleStruct{X: 1}},
 
+	{name: "truncated integer", in: "i", outputArg: int64(0),
+		wantOutput: int64(0),
+		wantErr:    "expected integer at offset 1"},
 	{name: "missing integer", in: "ie", outputArg: int64(0),
 		wantOutput: int64(0),
 		wantErr:    "expected integer at offset 1"},
@@ -70,6 +186,36 @@ var decodeTests = []struct {
 	{name: "three letter string", in: "3:abc", outputArg: "",
 		wantOutput: "abc"},
 
+	{name: "non-ascii string", in: "2:§", outputArg: "",
+		wantOutput: "§"},
+	{name: "binary string", in: "3:\x00\xff\x80", outputArg: "",
+		wantOutput: "\x00\xff\x80"},
+
+	{name: "empty byte slice", in: "0:", outputArg: []byte(nil),
+		wantOutput: []byte{}},
+	{name: "byte slice", in: "4:\x00\xffab", outputArg: []byte(nil),
+		wantOutput: []byte{0x00, 0xff, 'a', 'b'}},
+	{name: "byte array", in: "4:\x00\xffab", outputArg: [4]byte{},
+		wantOutput: [4]byte{0x00, 0xff, 'a', 'b'}},
+	{name: "named byte slice", in: "2:ab", outputArg: []byteKind(nil),
+		wantOutput: []byteKind{'a', 'b'}},
+	{name: "named byte array", in: "2:ab", outputArg: [2]byteKind{},
+		wantOutput: [2]byteKind{'a', 'b'}},
+	{name: "byte slices list", in: "l1:a2:\xfe\xffe", outputArg: [][]byte(nil),
+		wantOutput: [][]byte{{'a'}, {0xfe, 0xff}}},
+	{name: "byte fields", in: "d4:hash2:\x01\x026:pieces3:\xde\xad\xbee",
+		outputArg: struct {
+			Hash   [2]byte `bencode:"hash"`
+			Pieces []byte  `bencode:"pieces"`
+		}{},
+		wantOutput: struct {
+			Hash   [2]byte `bencode:"hash"`
+			Pieces []byte  `bencode:"pieces"`
+		}{Hash: [2]byte{0x01, 0x02}, Pieces: []byte{0xde, 0xad, 0xbe}}},
+	{name: "wrong length for byte array", in: "3:abc", outputArg: [4]byte{},
+		wantOutput: [4]byte{},
+		wantErr:    "cannot unmarshal string of length 3 at offset 0 into [4]uint8"},
+
 	{name: "extra data string 1", in: "0:abc", outputArg: "",
 		wantOutput: "",
 		wantErr:    "trailing data at offset 2 cannot be parsed"},
@@ -83,6 +229,9 @@ var decodeTests = []struct {
 	{name: "incorrect length string", in: "100:abc", outputArg: "",
 		wantOutput: "",
 		wantErr:    "string at offset 0 has length 100, yet there are not that many bytes left"},
+	{name: "overflowing string length", in: "9223372036854775807:abc", outputArg: "",
+		wantOutput: "",
+		wantErr:    "string at offset 0 has length 9223372036854775807, yet there are not that many bytes left"},
 
 	{name: "empty list", in: "le", outputArg: []int64{}, wantOutput: *new([]int64)},
 	{name: "single-element integer list", in: "li651ee", outputArg: []int64{},
@@ -170,6 +319,9 @@ var decodeTests = []struct {
 	{name: "unterminated dictionary 1", in: "d", outputArg: struct{}{},
 		wantOutput: struct{}{},
 		wantErr:    "expected terminator for dictionary at offset 1"},
+	{name: "unterminated dictionary 2", in: "d3:abc", outputArg: struct{}{},
+		wantOutput: struct{}{},
+		wantErr:    "no data to read at offset 6"},
 
 	{name: "undefined key dictionary 1", in: "d3:abci651ee", outputArg: struct{}{},
 		wantOutput: struct{}{}},
@@ -185,6 +337,153 @@ var decodeTests = []struct {
 		wantOutput: struct{}{},
 		wantErr:    "expected integer at offset 8"},
 
+	{name: "empty dictionary into map", in: "de", outputArg: map[string]int64(nil),
+		wantOutput: map[string]int64{}},
+	{name: "integer map", in: "d1:ai1e2:bbi2ee", outputArg: map[string]int64(nil),
+		wantOutput: map[string]int64{"a": 1, "bb": 2}},
+	{name: "string-kinded map keys", in: "d1:a1:x1:b1:ye", outputArg: map[stringKind]string(nil),
+		wantOutput: map[stringKind]string{"a": "x", "b": "y"}},
+	{name: "struct map", in: "d3:oned1:xi1ee3:twod1:xi2e3:zzz1:zee", outputArg: map[string]simpleStruct(nil),
+		wantOutput: map[string]simpleStruct{"one": {X: 1}, "two": {X: 2, Z: "z"}}},
+	{name: "list map", in: "d1:ali1ei2ee1:blee", outputArg: map[string][]int64(nil),
+		wantOutput: map[string][]int64{"a": {1, 2}, "b": nil}},
+	{name: "nested map", in: "d1:ad1:bi1eee", outputArg: map[string]map[string]int64(nil),
+		wantOutput: map[string]map[string]int64{"a": {"b": 1}}},
+	{name: "map inside struct", in: "d5:filesd5:a.txti10e5:b.txti20eee", outputArg: mapStruct{},
+		wantOutput: mapStruct{Files: map[string]int64{"a.txt": 10, "b.txt": 20}}},
+	{name: "generic map", in: "d1:ai1e1:bl1:xe1:cd1:yi2ee1:dlee", outputArg: map[string]interface{}(nil),
+		wantOutput: map[string]interface{}{
+			"a": int64(1),
+			"b": []interface{}{"x"},
+			"c": map[string]interface{}{"y": int64(2)},
+			"d": []interface{}{},
+		}},
+	{name: "wrong key type for map", in: "de", outputArg: map[int]string(nil),
+		wantOutput: map[int]string(nil),
+		wantErr:    "cannot unmarshal dictionary at offset 0 into map[int]string"},
+	{name: "wrong value type for map", in: "d1:ai1e1:b1:xe", outputArg: map[string]int64(nil),
+		wantOutput: map[string]int64(nil),
+		wantErr:    "cannot unmarshal string at offset 10 into field b of type int64"},
+	{name: "malformed generic map", in: "d1:ai1e1:bxe", outputArg: map[string]interface{}(nil),
+		wantOutput: map[string]interface{}(nil),
+		wantErr:    "expected start of integer, string, list, or dictionary at offset 10"},
+
+	{name: "generic integer", in: "i-651e", outputArg: (*interface{})(nil),
+		wantOutput: int64(-651)},
+	{name: "generic string", in: "5:hello", outputArg: (*interface{})(nil),
+		wantOutput: "hello"},
+	{name: "generic empty list", in: "le", outputArg: (*interface{})(nil),
+		wantOutput: []interface{}{}},
+	{name: "generic list", in: "li1e1:alee", outputArg: (*interface{})(nil),
+		wantOutput: []interface{}{int64(1), "a", []interface{}{}}},
+	{name: "generic empty dictionary", in: "de", outputArg: (*interface{})(nil),
+		wantOutput: map[string]interface{}{}},
+	{name: "generic nested value", in: "d4:infod5:filesld6:lengthi10eeee8:announce3:urle",
+		outputArg: (*interface{})(nil),
+		wantOutput: map[string]interface{}{
+			"announce": "url",
+			"info": map[string]interface{}{
+				"files": []interface{}{map[string]interface{}{"length": int64(10)}},
+			},
+		}},
+	{name: "generic list inside struct", in: "d1:xi1e3:zzzli1e1:aee",
+		outputArg: struct {
+			Z interface{} `bencode:"zzz"`
+		}{},
+		wantOutput: struct {
+			Z interface{} `bencode:"zzz"`
+		}{Z: []interface{}{int64(1), "a"}}},
+	{name: "malformed generic value", in: "li1exe", outputArg: (*interface{})(nil),
+		wantOutput: nil,
+		wantErr:    "expected start of integer, string, list, or dictionary at offset 4"},
+	{name: "wrong output type for non-empty interface", in: "i1e", outputArg: (*fmt.Stringer)(nil),
+		wantOutput: fmt.Stringer(nil),
+		wantErr:    "cannot unmarshal integer at offset 0 into fmt.Stringer"},
+
+	{name: "unmarshaler", in: "5:a,b,c", outputArg: commaList{},
+		wantOutput: commaList{"a", "b", "c"}},
+	{name: "unmarshaler list", in: "l1:a3:b,ce", outputArg: []commaList{},
+		wantOutput: []commaList{{"a"}, {"b", "c"}}},
+	{name: "unmarshaler map", in: "d1:xli1ei2eee", outputArg: map[string]rawCapture(nil),
+		wantOutput: map[string]rawCapture{"x": {raw: "li1ei2ee"}}},
+	{name: "unmarshaler fields", in: "d4:list3:a,b7:pointerd1:xi1ee5:valuelee",
+		outputArg: unmarshalerStruct{},
+		wantOutput: unmarshalerStruct{
+			List:    commaList{"a", "b"},
+			Pointer: &rawCapture{raw: "d1:xi1ee"},
+			Value:   rawCapture{raw: "le"},
+		}},
+	{name: "malformed unmarshaler input", in: "d7:pointerli1e", outputArg: unmarshalerStruct{},
+		wantOutput: unmarshalerStruct{},
+		wantErr:    "expected terminator for list at offset 14"},
+	{name: "failing unmarshaler", in: "d5:valuei1e4:listi1ee", outputArg: unmarshalerStruct{},
+		wantOutput: unmarshalerStruct{},
+		wantErr:    "error calling UnmarshalBencode for bencode.commaList at offset 17: cannot unmarshal integer at offset 0 into string"},
+
+	{name: "pointer to integer", in: "i651e", outputArg: (*int64)(nil),
+		wantOutput: func() *int64 { i := int64(651); return &i }()},
+	{name: "pointer to pointer", in: "3:abc", outputArg: (**string)(nil),
+		wantOutput: func() **string { s := "abc"; p := &s; return &p }()},
+	{name: "pointer list", in: "li1ei2ee", outputArg: []*int{},
+		wantOutput: []*int{func() *int { i := 1; return &i }(), func() *int { i := 2; return &i }()}},
+	{name: "pointer fields", in: "d3:inti-1e6:nestedd4:intsli1eee5:slicel1:ae6:structd1:xi1eee",
+		outputArg: pointerStruct{},
+		wantOutput: pointerStruct{
+			Int:    func() *int64 { i := int64(-1); return &i }(),
+			Slice:  &[]string{"a"},
+			Struct: &simpleStruct{X: 1},
+			Nested: func() **compositStruct { c := &compositStruct{IntList: []int64{1}}; return &c }(),
+		}},
+	{name: "absent pointer fields", in: "d5:slicelee", outputArg: pointerStruct{},
+		wantOutput: pointerStruct{Slice: new([]string)}},
+	{name: "wrong type for pointer field", in: "d5:slicel1:a1:be6:structi1ee", outputArg: pointerStruct{},
+		wantOutput: pointerStruct{},
+		wantErr:    "cannot unmarshal integer at offset 24 into field struct of type bencode.simpleStruct"},
+
+	{name: "untagged field", in: "d7:Unnamed5:helloe", outputArg: simpleStruct{},
+		wantOutput: simpleStruct{Unnamed: "hello"}},
+	{name: "tag options", in: "d1:-1:a5:NoKey1:b8:Untagged1:c7:Ignored1:x4:omit1:d10:unexported1:ye",
+		outputArg:  tagOptionsStruct{},
+		wantOutput: tagOptionsStruct{Untagged: "c", Dash: "a", OmitEmpty: "d", NoKey: "b"}},
+	{name: "ignored fields are skipped", in: "d7:Ignoredi1e10:unexportedli1eee",
+		outputArg:  tagOptionsStruct{},
+		wantOutput: tagOptionsStruct{}},
+
+	{name: "embedded struct", in: "d8:announce3:url7:comment2:hi4:infod4:name5:a.txtee",
+		outputArg: metainfoV1{},
+		wantOutput: metainfoV1{
+			MetainfoBase: MetainfoBase{Announce: "url", Comment: "hi"},
+			Info: struct {
+				Name string `bencode:"name"`
+			}{Name: "a.txt"},
+		}},
+	{name: "embedded pointer", in: "d8:announce3:url7:comment5:shown13:creation datei1e12:meta versioni2ee",
+		outputArg: metainfoV2{},
+		wantOutput: metainfoV2{
+			MetainfoBase: &MetainfoBase{Announce: "url", CreationDate: 1},
+			Comment:      "shown",
+			Version:      2,
+		}},
+	{name: "absent embedded pointer", in: "d7:comment5:shown12:meta versioni2ee",
+		outputArg:  metainfoV2{},
+		wantOutput: metainfoV2{Comment: "shown", Version: 2}},
+	{name: "unexported embedded struct", in: "d8:exported1:a10:unexported1:be",
+		outputArg:  struct{ unexportedBase }{},
+		wantOutput: struct{ unexportedBase }{unexportedBase{Exported: "a"}}},
+	{name: "unexported embedded pointer", in: "d8:exported1:ae",
+		outputArg:  struct{ *unexportedBase }{},
+		wantOutput: struct{ *unexportedBase }{},
+		wantErr:    "cannot set embedded pointer to unexported struct bencode.unexportedBase at offset 11"},
+	{name: "conflicting embedded fields", in: "d8:announce3:url5:extra1:ee",
+		outputArg: struct {
+			MetainfoBase
+			OtherBase
+		}{},
+		wantOutput: struct {
+			MetainfoBase
+			OtherBase
+		}{OtherBase: OtherBase{Extra: "e"}}},
+
 	{name: "wrong output type for integer", in: "i651e", outputArg: "",
 		wantOutput: "",
 		wantErr:    "cannot unmarshal integer at offset 0 into string"},
@@ -200,13 +499,19 @@ var decodeTests = []struct {
 	{name: "wrong output type for composit dictionary", in: "d4:intsl3:badee",
 		outputArg:  compositStruct{},
 		wantOutput: compositStruct{},
-		wantErr:    "cannot unmarshal string at offset 8 into int64"},
+		wantErr:    "cannot unmarshal string at offset 8 into field ints[0] of type int64"},
 }
 
 func TestDecode(t *testing.T) {
 	for _, testCase := range decodeTests {
 		t.Run(testCase.name, func(t *testing.T) {
-			got := reflect.New(reflect.TypeOf(testCase.outputArg))
+			// Interface types can only be named through a pointer, so a nil
+			// pointer to an interface stands for the interface itself.
+			outputType := reflect.TypeOf(testCase.outputArg)
+			if outputType.Kind() == reflect.Ptr && outputType.Elem().Kind() == reflect.Interface {
+				outputType = outputType.Elem()
+			}
+			got := reflect.New(outputType)
 			err := Unmarshal([]byte(testCase.in), got.Interface())
 			if testCase.wantErr != "" || err != nil {
 				if err == nil {
@@ -222,3 +527,327 @@ func TestDecode(t *testing.T) {
 		})
 	}
 }
+
+var strictDecodeTests = []struct {
+	name       string
+	in         string
+	outputArg  interface{}
+	wantErr    string
+	wantOutput interface{}
+}{
+	{name: "zero", in: "i0e", outputArg: int64(0), wantOutput: int64(0)},
+	{name: "negative integer", in: "i-10e", outputArg: int64(0), wantOutput: int64(-10)},
+	{name: "empty string", in: "0:", outputArg: "", wantOutput: ""},
+	{name: "sorted keys", in: "d1:Xi1e1:Yi2e1:xi3ee", outputArg: map[string]int64{},
+		wantOutput: map[string]int64{"X": 1, "Y": 2, "x": 3}},
+	{name: "key that is a prefix of the next", in: "d1:ai1e2:aai2ee", outputArg: map[string]int64{},
+		wantOutput: map[string]int64{"a": 1, "aa": 2}},
+
+	{name: "leading zero in integer", in: "i01e", outputArg: int64(0),
+		wantOutput: int64(0),
+		wantErr:    "integer at offset 1 has a leading zero"},
+	{name: "leading zero in negative integer", in: "li1ei-01ee", outputArg: []int64{},
+		wantOutput: *new([]int64),
+		wantErr:    "integer at offset 5 has a leading zero"},
+	{name: "multiple zeros", in: "i00e", outputArg: int64(0),
+		wantOutput: int64(0),
+		wantErr:    "integer at offset 1 has a leading zero"},
+	{name: "negative zero", in: "i-0e", outputArg: int64(0),
+		wantOutput: int64(0),
+		wantErr:    "integer at offset 1 is negative zero"},
+	{name: "leading zero in skipped integer", in: "d1:zi01ee", outputArg: simpleStruct{},
+		wantOutput: simpleStruct{},
+		wantErr:    "integer at offset 5 has a leading zero"},
+	{name: "leading zero in string length", in: "03:abc", outputArg: "",
+		wantOutput: "",
+		wantErr:    "length for string at offset 0 has a leading zero"},
+	{name: "zero string length with leading zero", in: "00:", outputArg: "",
+		wantOutput: "",
+		wantErr:    "length for string at offset 0 has a leading zero"},
+	{name: "leading zero in key length", in: "d01:xi1ee", outputArg: simpleStruct{},
+		wantOutput: simpleStruct{},
+		wantErr:    "length for string at offset 1 has a leading zero"},
+	{name: "unsorted keys", in: "d1:yi1e1:xi2ee", outputArg: map[string]int64{},
+		wantOutput: *new(map[string]int64),
+		wantErr:    "dictionary key at offset 7 is not sorted after the previous key: \"x\""},
+	{name: "keys sorted case-insensitively", in: "d1:ai1e1:Bi2ee", outputArg: map[string]int64{},
+		wantOutput: *new(map[string]int64),
+		wantErr:    "dictionary key at offset 7 is not sorted after the previous key: \"B\""},
+	{name: "duplicate keys", in: "d1:xi1e1:xi2ee", outputArg: simpleStruct{},
+		wantOutput: simpleStruct{},
+		wantErr:    "duplicate dictionary key at offset 7: \"x\""},
+	{name: "unsorted keys in nested dictionary", in: "ld1:bi1e1:ai2eee", outputArg: []map[string]int64{},
+		wantOutput: *new([]map[string]int64),
+		wantErr:    "dictionary key at offset 8 is not sorted after the previous key: \"a\""},
+	{name: "keys only sorted in nested dictionaries", in: "d1:bd1:ai1ee1:ad1:bi1eee", outputArg: map[string]map[string]int64{},
+		wantOutput: *new(map[string]map[string]int64),
+		wantErr:    "dictionary key at offset 12 is not sorted after the previous key: \"a\""},
+}
+
+func TestDecodeStrict(t *testing.T) {
+	for _, testCase := range strictDecodeTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := reflect.New(reflect.TypeOf(testCase.outputArg))
+			err := DecoderOptions{Strict: true}.Unmarshal([]byte(testCase.in), got.Interface())
+			if testCase.wantErr != "" || err != nil {
+				if err == nil {
+					t.Errorf("want error with message '%v', got no error", testCase.wantErr)
+				} else if err.Error() != testCase.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+				}
+			}
+			if !reflect.DeepEqual(got.Elem().Interface(), testCase.wantOutput) {
+				t.Errorf("got output '%+v', want '%+v'", got.Elem().Interface(), testCase.wantOutput)
+			}
+
+			// The default options accept non-canonical input.
+			lenient := reflect.New(reflect.TypeOf(testCase.outputArg))
+			if err := Unmarshal([]byte(testCase.in), lenient.Interface()); err != nil {
+				t.Errorf("got unexpected error without Strict: %v", err)
+			}
+		})
+	}
+}
+
+var limitTests = []struct {
+	name       string
+	in         string
+	opts       DecoderOptions
+	wantErr    error
+	wantOffset int
+}{
+	{name: "depth within limit", in: "lli1eee", opts: DecoderOptions{MaxDepth: 2}},
+	{name: "depth", in: "llli1eeee", opts: DecoderOptions{MaxDepth: 2},
+		wantErr: ErrMaxDepth, wantOffset: 2},
+	{name: "dictionary depth", in: "d1:ad1:bleee", opts: DecoderOptions{MaxDepth: 2},
+		wantErr: ErrMaxDepth, wantOffset: 8},
+	{name: "default depth within limit", in: strings.Repeat("l", DefaultMaxDepth) + strings.Repeat("e", DefaultMaxDepth)},
+	{name: "default depth", in: strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1),
+		wantErr: ErrMaxDepth, wantOffset: DefaultMaxDepth},
+	{name: "string length within limit", in: "l3:abce", opts: DecoderOptions{MaxStringLength: 3}},
+	{name: "string length", in: "l3:abc4:abcde", opts: DecoderOptions{MaxStringLength: 3},
+		wantErr: ErrMaxStringLength, wantOffset: 6},
+	{name: "key length", in: "d4:abcdi1ee", opts: DecoderOptions{MaxStringLength: 3},
+		wantErr: ErrMaxStringLength, wantOffset: 1},
+	{name: "declared length beyond input", in: "99999999999:abc", opts: DecoderOptions{MaxStringLength: 1 << 20},
+		wantErr: ErrMaxStringLength, wantOffset: 0},
+	{name: "elements within limit", in: "lli1eed1:ai2eee", opts: DecoderOptions{MaxElements: 5}},
+	{name: "elements", in: "lli1eed1:ai2eei3ee", opts: DecoderOptions{MaxElements: 5},
+		wantErr: ErrMaxElements, wantOffset: 14},
+	{name: "dictionary entries count twice", in: "d1:ai1e1:bi2ee", opts: DecoderOptions{MaxElements: 3},
+		wantErr: ErrMaxElements, wantOffset: 10},
+	{name: "input size within limit", in: "li1ee", opts: DecoderOptions{MaxInputSize: 5}},
+	{name: "input size", in: "li12ee", opts: DecoderOptions{MaxInputSize: 5},
+		wantErr: ErrMaxInputSize, wantOffset: 5},
+}
+
+func TestDecodeLimits(t *testing.T) {
+	for _, testCase := range limitTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			var v interface{}
+			err := testCase.opts.Unmarshal([]byte(testCase.in), &v)
+			if testCase.wantErr == nil {
+				if err != nil {
+					t.Errorf("got unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("got error '%v', want a *LimitError", err)
+			}
+			if limitErr.Offset != testCase.wantOffset {
+				t.Errorf("got offset %d, want %d", limitErr.Offset, testCase.wantOffset)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, testCase := range decodeTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			// Decoding into an empty interface only fails on invalid input,
+			// or on integers that do not fit in an int64.
+			var v interface{}
+			err := Unmarshal([]byte(testCase.in), &v)
+			var typeErr *UnmarshalTypeError
+			want := err == nil || errors.As(err, &typeErr)
+			if got := Valid([]byte(testCase.in)); got != want {
+				t.Errorf("got %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, testCase := range strictDecodeTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := Check([]byte(testCase.in), DecoderOptions{Strict: true})
+			if testCase.wantErr == "" {
+				if err != nil {
+					t.Errorf("got unexpected error: %v", err)
+				}
+				return
+			}
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || err.Error() != testCase.wantErr {
+				t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+		})
+	}
+	for _, testCase := range limitTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := Check([]byte(testCase.in), testCase.opts)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckIgnoresTypes(t *testing.T) {
+	// Check has no destination, so any well-formed value is accepted,
+	// including integers that do not fit in 64 bits.
+	in := "d1:ai99999999999999999999999e1:bl3:abcee"
+	if err := Check([]byte(in), DecoderOptions{Strict: true}); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+}
+
+func TestDecodeIntoInterfaceHoldingPointer(t *testing.T) {
+	var s simpleStruct
+	var got interface{} = &s
+	if err := Unmarshal([]byte("d1:xi651ee"), &got); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if got != &s {
+		t.Errorf("got interface value %v, want it to still point to the original struct", got)
+	}
+	if want := (simpleStruct{X: 651}); s != want {
+		t.Errorf("got output '%+v', want '%+v'", s, want)
+	}
+}
+
+func TestDecodeBigInt(t *testing.T) {
+	for _, in := range []string{"0", "-1", "18446744073709551616", "-123456789012345678901234567890"} {
+		want, _ := new(big.Int).SetString(in, 10)
+
+		var value big.Int
+		if err := Unmarshal([]byte("i"+in+"e"), &value); err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if value.Cmp(want) != 0 {
+			t.Errorf("got output '%v', want '%v'", &value, want)
+		}
+
+		var fields struct {
+			Pointer *big.Int  `bencode:"pointer"`
+			List    []big.Int `bencode:"list"`
+		}
+		if err := Unmarshal([]byte("d7:pointeri"+in+"e4:listli"+in+"eee"), &fields); err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if fields.Pointer == nil || fields.Pointer.Cmp(want) != 0 {
+			t.Errorf("got pointer field '%v', want '%v'", fields.Pointer, want)
+		}
+		if len(fields.List) != 1 || fields.List[0].Cmp(want) != 0 {
+			t.Errorf("got list field '%v', want '[%v]'", fields.List, want)
+		}
+	}
+}
+
+func TestDecodeBigIntDoesNotModifyExistingValue(t *testing.T) {
+	original := big.NewInt(651)
+	fields := struct {
+		Pointer *big.Int `bencode:"pointer"`
+	}{Pointer: original}
+	if err := Unmarshal([]byte("d7:pointeri123ee"), &fields); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if fields.Pointer.Int64() != 123 || original.Int64() != 651 {
+		t.Errorf("got field '%v' and original '%v', want 123 and 651", fields.Pointer, original)
+	}
+}
+
+func TestDecodeIntoExistingPointer(t *testing.T) {
+	existing := &simpleStruct{X: 1, Y: 2}
+	got := pointerStruct{Struct: existing}
+	if err := Unmarshal([]byte("d6:structd2:yyi3eee"), &got); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if got.Struct != existing {
+		t.Errorf("got a new pointer, want the existing pointer to be reused")
+	}
+	if want := (simpleStruct{X: 1, Y: 3}); *existing != want {
+		t.Errorf("got output '%+v', want '%+v'", *existing, want)
+	}
+}
+
+func TestDecodeFailureLeavesOutputUnchanged(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		// newOutput returns a fresh copy of the output's initial state each
+		// time it is called.
+		newOutput func() interface{}
+	}{
+		{name: "existing map entries", in: "d5:filesd1:ai2e1:bi3eeex",
+			newOutput: func() interface{} {
+				return &mapStruct{Files: map[string]int64{"a": 1}}
+			}},
+		{name: "nil map", in: "d5:filesd1:ai2e1:bi3eeex",
+			newOutput: func() interface{} { return &mapStruct{} }},
+		{name: "existing pointer", in: "d6:structd1:xi5e2:yyi6ee3:int3:abce",
+			newOutput: func() interface{} {
+				return &pointerStruct{Struct: &simpleStruct{X: 1, Y: 2}}
+			}},
+		{name: "nil pointers", in: "d3:inti5e6:nestedd4:intsli1eee5:slicei1ee",
+			newOutput: func() interface{} { return &pointerStruct{} }},
+		{name: "existing slice", in: "d4:intsli2ei3ee7:stringsli1eee",
+			newOutput: func() interface{} {
+				return &compositStruct{IntList: []int64{1}}
+			}},
+		{name: "failing unmarshaler", in: "d7:pointer3:abc5:value3:def4:listi1ee",
+			newOutput: func() interface{} {
+				return &unmarshalerStruct{
+					List:    commaList{"a"},
+					Pointer: &rawCapture{raw: "b"},
+					Value:   rawCapture{raw: "c"},
+				}
+			}},
+		{name: "interface", in: "d1:ai1e1:bi2e",
+			newOutput: func() interface{} {
+				var v interface{} = map[string]interface{}{"a": int64(0)}
+				return &v
+			}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := testCase.newOutput()
+			if err := Unmarshal([]byte(testCase.in), got); err == nil {
+				t.Fatalf("want error, got no error")
+			}
+			if want := testCase.newOutput(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got output '%+v', want the output to be left as '%+v'", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, err := Marshal(newBenchmarkAnnounce())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var announce benchmarkAnnounce
+		if err := Unmarshal(data, &announce); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,109 +3,360 @@ package bencode
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"math/big"
 	"reflect"
 	"sort"
 	"strconv"
-	"unicode"
 )
 
 // Marshal returns a bencode encoding of v.
+//
+// Pointers are encoded as the values they point to. Bencode has no null value,
+// so nil pointers and interfaces are omitted when they appear as struct fields
+// or map values, and are an error anywhere else. A value that contains itself
+// through a pointer, map, or slice causes an *UnsupportedValueError.
+//
+// Types with no Bencode representation, such as floating-point numbers,
+// booleans, channels, and functions, cause Marshal to return an
+// *UnsupportedTypeError. Marshal never returns partial or invalid output.
 func Marshal(v interface{}) ([]byte, error) {
 	var buf bytes.Buffer
-	if err := marshal(reflect.ValueOf(v), &buf); err != nil {
+	if err := marshal(reflect.ValueOf(v), &encodeState{writer: &buf}); err != nil {
 		return nil, err
 	}
+	// Check the output, so that a bug in the encoder surfaces as an error
+	// rather than as corrupt data.
+	if err := checkValid(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("Marshal produced invalid Bencode for %T: %w", v, err)
+	}
 	return buf.Bytes(), nil
 }
 
-func marshal(v reflect.Value, buf *bytes.Buffer) error {
+// writer is the destination of the marshal* helpers. It is satisfied by both
+// *bytes.Buffer, used by Marshal, and *bufio.Writer, used by Encoder.
+type writer interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// startDetectingCyclesAfter is the number of nested pointers, maps, and slices
+// after which marshal starts looking for cycles. Checking only deeply nested
+// values keeps the common case cheap.
+const startDetectingCyclesAfter = 1000
+
+// encodeState is the writer that marshal writes to, along with the pointers,
+// maps, and slices it is in the middle of encoding.
+type encodeState struct {
+	writer
+
+	ptrLevel int
+	ptrSeen  map[ptrKey]struct{}
+}
+
+// ptrKey identifies a pointer, map, or slice. A slice's length is included,
+// since slices of different lengths that share an address are not a cycle.
+type ptrKey struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// enter records that marshal is encoding v, which is a pointer, map, or
+// slice. It returns an *UnsupportedValueError if v is already being encoded,
+// which means that v contains itself.
+func (e *encodeState) enter(v reflect.Value) error {
+	e.ptrLevel++
+	if e.ptrLevel <= startDetectingCyclesAfter {
+		return nil
+	}
+	if e.ptrSeen == nil {
+		e.ptrSeen = map[ptrKey]struct{}{}
+	}
+	key := newPtrKey(v)
+	if _, ok := e.ptrSeen[key]; ok {
+		return &UnsupportedValueError{Value: v, Str: "value of type " + v.Type().String() + ": encountered a cycle"}
+	}
+	e.ptrSeen[key] = struct{}{}
+	return nil
+}
+
+// exit undoes a successful call to enter.
+func (e *encodeState) exit(v reflect.Value) {
+	if e.ptrLevel > startDetectingCyclesAfter {
+		delete(e.ptrSeen, newPtrKey(v))
+	}
+	e.ptrLevel--
+}
+
+func newPtrKey(v reflect.Value) ptrKey {
+	key := ptrKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	return key
+}
+
+// Marshaler is the interface implemented by types that can marshal themselves
+// into valid Bencode.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+var (
+	bigIntType    = reflect.TypeOf(big.Int{})
+	bigIntPtrType = reflect.TypeOf((*big.Int)(nil))
+)
+
+func marshal(v reflect.Value, e *encodeState) error {
+	if !v.IsValid() {
+		return &UnsupportedValueError{Value: v, Str: "nil"}
+	}
+	if v.Kind() != reflect.Interface && v.CanInterface() {
+		if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+			v = v.Addr()
+		}
+		if v.Type().Implements(marshalerType) {
+			return marshalCustom(v, e)
+		}
+
+		switch v.Type() {
+		case bigIntType:
+			i := v.Interface().(big.Int)
+			marshalBigInt(&i, e)
+			return nil
+		case bigIntPtrType:
+			if v.IsNil() {
+				return nilValueError(v)
+			}
+			marshalBigInt(v.Interface().(*big.Int), e)
+			return nil
+		}
+	}
+
 	var err error
 	switch v.Kind() {
 	case reflect.Interface:
-		err = marshal(v.Elem(), buf)
+		if v.IsNil() {
+			return nilValueError(v)
+		}
+		err = marshal(v.Elem(), e)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nilValueError(v)
+		}
+		if err := e.enter(v); err != nil {
+			return err
+		}
+		err = marshal(v.Elem(), e)
+		e.exit(v)
 	case reflect.Int,
 		reflect.Int8,
 		reflect.Int16,
 		reflect.Int32,
 		reflect.Int64:
-		marshalInt(int(v.Int()), buf)
+		marshalInt(v.Int(), e)
 	case reflect.Uint,
 		reflect.Uint8,
 		reflect.Uint16,
 		reflect.Uint32,
-		reflect.Uint64:
-		marshalInt(int(v.Uint()), buf)
+		reflect.Uint64,
+		reflect.Uintptr:
+		marshalUint(v.Uint(), e)
 	case reflect.String:
-		err = marshalString(v.String(), buf)
-	case reflect.Array, reflect.Slice:
-		err = marshalList(v, buf)
+		marshalString(v.String(), e)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			marshalBytes(v.Bytes(), e)
+			break
+		}
+		if err := e.enter(v); err != nil {
+			return err
+		}
+		err = marshalList(v, e)
+		e.exit(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			for i := range b {
+				b[i] = byte(v.Index(i).Uint())
+			}
+			marshalBytes(b, e)
+			break
+		}
+		err = marshalList(v, e)
+	case reflect.Map:
+		if err := e.enter(v); err != nil {
+			return err
+		}
+		err = marshalMap(v, e)
+		e.exit(v)
 	case reflect.Struct:
-		err = marshalStruct(v, buf)
+		err = marshalStruct(v, e)
 	default:
-		return fmt.Errorf("encountered unsupported type: %s", v.Kind().String())
+		return &UnsupportedTypeError{Type: v.Type()}
 	}
 	return err
 }
 
-func marshalInt(i int, buf *bytes.Buffer) {
-	buf.WriteRune('i')
-	buf.WriteString(strconv.Itoa(i))
-	buf.WriteRune('e')
+// nilValueError returns the error for the nil pointer or interface v, which
+// cannot be encoded where it appears.
+func nilValueError(v reflect.Value) error {
+	kind := "pointer"
+	if v.Kind() == reflect.Interface {
+		kind = "interface"
+	}
+	return &UnsupportedValueError{Value: v, Str: "nil " + kind + " of type " + v.Type().String()}
 }
 
-func isASCII(s string) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i] > unicode.MaxASCII {
-			return false
-		}
+// marshalCustom serializes a value that implements Marshaler. The output of
+// MarshalBencode is checked before it is written, so that a faulty Marshaler
+// cannot corrupt the surrounding data.
+func marshalCustom(v reflect.Value, buf writer) error {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nilValueError(v)
+	}
+	b, err := v.Interface().(Marshaler).MarshalBencode()
+	if err != nil {
+		return fmt.Errorf("error calling MarshalBencode for %s: %w", v.Type(), err)
+	}
+	if err := checkValid(b); err != nil {
+		return fmt.Errorf("MarshalBencode for %s returned invalid Bencode: %w", v.Type(), err)
 	}
-	return true
+	buf.Write(b)
+	return nil
 }
 
-func marshalString(s string, buf *bytes.Buffer) error {
-	if !isASCII(s) {
-		return fmt.Errorf("strings may not contain non-ascii characters: %s", s)
-	}
+func marshalInt(i int64, buf writer) {
+	buf.WriteByte('i')
+	buf.WriteString(strconv.FormatInt(i, 10))
+	buf.WriteByte('e')
+}
+
+func marshalUint(u uint64, buf writer) {
+	buf.WriteByte('i')
+	buf.WriteString(strconv.FormatUint(u, 10))
+	buf.WriteByte('e')
+}
+
+// marshalBigInt serializes an arbitrary-precision integer. Bencode places no
+// limit on the size of integers.
+func marshalBigInt(i *big.Int, buf writer) {
+	buf.WriteByte('i')
+	buf.WriteString(i.String())
+	buf.WriteByte('e')
+}
+
+// marshalString serializes a string. Bencode strings are arbitrary byte
+// sequences, so s is written as is, whatever its encoding.
+func marshalString(s string, buf writer) {
 	buf.WriteString(strconv.Itoa(len(s)))
-	buf.WriteRune(':')
+	buf.WriteByte(':')
 	buf.WriteString(s)
-	return nil
 }
 
-func marshalList(v reflect.Value, buf *bytes.Buffer) error {
-	buf.WriteRune('l')
+func marshalBytes(b []byte, buf writer) {
+	buf.WriteString(strconv.Itoa(len(b)))
+	buf.WriteByte(':')
+	buf.Write(b)
+}
+
+func marshalList(v reflect.Value, e *encodeState) error {
+	e.WriteByte('l')
 	for i := 0; i < v.Len(); i++ {
-		if err := marshal(v.Index(i), buf); err != nil {
+		if err := marshal(v.Index(i), e); err != nil {
 			return err
 		}
 	}
-	buf.WriteRune('e')
+	e.WriteByte('e')
 	return nil
 }
 
-// marshalStruct serializes a struct. Each field in the struct must have a
-// tag named "bencode" that specifies the key to use in the output. Per Bencode
-// specifications, the keys are ordered in the serialized output.
-func marshalStruct(v reflect.Value, buf *bytes.Buffer) error {
-	keys := make([]string, v.NumField())
-	keyToIndex := make(map[string]int, v.NumField())
-	for i := 0; i < v.NumField(); i++ {
-		key := v.Type().Field(i).Tag.Get("bencode")
-		if key == "" {
-			return fmt.Errorf("found struct field with no 'bencode' tag")
-		}
-		keys[i] = key
-		keyToIndex[key] = i
+var (
+	rawMessageType      = reflect.TypeOf(RawMessage(nil))
+	bencodeValueType    = reflect.TypeOf(Value{})
+	bencodeValuePtrType = reflect.TypeOf((*Value)(nil))
+)
+
+// isNil reports whether v is a nil pointer or interface, an empty RawMessage,
+// or the zero Value. Bencode has no null value, so such values are left out
+// of dictionaries.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr:
+		return v.IsNil()
+	case reflect.Interface:
+		return v.IsNil() || isNil(v.Elem())
+	case reflect.Slice:
+		return v.Type() == rawMessageType && v.Len() == 0
+	case reflect.Struct:
+		return v.Type() == bencodeValueType && v.CanInterface() && v.Interface().(Value).Kind() == InvalidValue
+	}
+	return false
+}
+
+// marshalMap serializes a map. The map's keys must be strings. Per Bencode
+// specifications, the keys are ordered by their raw bytes in the serialized
+// output.
+func marshalMap(v reflect.Value, e *encodeState) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return &UnsupportedTypeError{Type: v.Type()}
 	}
-	sort.Strings(keys)
 
-	buf.WriteRune('d')
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	e.WriteByte('d')
 	for _, key := range keys {
-		if err := marshalString(key, buf); err != nil {
+		elem := v.MapIndex(key)
+		if isNil(elem) {
+			continue
+		}
+		marshalString(key.String(), e)
+		if err := marshal(elem, e); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('e')
+	return nil
+}
+
+// fieldByIndex returns the field of the struct v with the given index
+// sequence. It returns the zero Value if the field is promoted through a nil
+// embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// marshalStruct serializes a struct. The fields that are included and the
+// keys they are stored under are determined by the struct's plan. Per Bencode
+// specifications, the keys are ordered in the serialized output.
+func marshalStruct(v reflect.Value, e *encodeState) error {
+	e.WriteByte('d')
+	for _, f := range cachedStructPlan(v.Type()).fields {
+		fieldValue := fieldByIndex(v, f.index)
+		if !fieldValue.IsValid() || isNil(fieldValue) || f.omitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+		marshalString(f.key, e)
+		if err := marshal(fieldValue, e); err != nil {
 			return err
 		}
-		marshal(v.Field(keyToIndex[key]), buf)
 	}
-	buf.WriteRune('e')
+	e.WriteByte('e')
 	return nil
 }
@@ -1,9 +1,88 @@
 package bencode
 
 import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 	"testing"
+	"unsafe"
 )
 
+type MetainfoBase struct {
+	Announce     string `bencode:"announce"`
+	CreationDate int64  `bencode:"creation date,omitempty"`
+	Comment      string `bencode:"comment,omitempty"`
+}
+
+type metainfoV1 struct {
+	MetainfoBase
+	Info struct {
+		Name string `bencode:"name"`
+	} `bencode:"info"`
+}
+
+type metainfoV2 struct {
+	*MetainfoBase
+	Comment string `bencode:"comment"`
+	Version int    `bencode:"meta version"`
+}
+
+type unexportedBase struct {
+	Exported   string `bencode:"exported"`
+	unexported string
+}
+
+type OtherBase struct {
+	Announce string `bencode:"announce"`
+	Extra    string `bencode:"extra"`
+}
+
+type unsupportedEmbedded struct {
+	Map map[bool]string `bencode:"map"`
+}
+
+type Embedded1 struct {
+	Key string
+}
+
+type Embedded2 struct {
+	Key string `bencode:"Key"`
+}
+
+// lengthMarshaler encodes itself as the length of its contents.
+type lengthMarshaler string
+
+func (m lengthMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i" + strconv.Itoa(len(m)) + "e"), nil
+}
+
+// pointerMarshaler encodes itself as a list holding its field, and only
+// implements Marshaler on its pointer type.
+type pointerMarshaler struct {
+	n int
+}
+
+func (m *pointerMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("li" + strconv.Itoa(m.n) + "ee"), nil
+}
+
+// invalidMarshaler produces malformed Bencode.
+type invalidMarshaler struct{}
+
+func (invalidMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i1ei2e"), nil
+}
+
+// failingMarshaler always fails.
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalBencode() ([]byte, error) {
+	return nil, errors.New("no can do")
+}
+
 var encodeTests = []struct {
 	name       string
 	in         interface{}
@@ -42,13 +121,46 @@ var encodeTests = []struct {
 	{name: "positive uint32", in: uint32(123), wantOutput: "i123e"},
 	{name: "zero uint32", in: uint32(0), wantOutput: "i0e"},
 
-	{name: "positive int64", in: uint64(123), wantOutput: "i123e"},
-	{name: "zero int64", in: uint64(0), wantOutput: "i0e"},
+	{name: "positive uint64", in: uint64(123), wantOutput: "i123e"},
+	{name: "zero uint64", in: uint64(0), wantOutput: "i0e"},
+
+	{name: "max int64", in: int64(math.MaxInt64), wantOutput: "i9223372036854775807e"},
+	{name: "min int64", in: int64(math.MinInt64), wantOutput: "i-9223372036854775808e"},
+	{name: "max uint32", in: uint32(math.MaxUint32), wantOutput: "i4294967295e"},
+	{name: "max uint64", in: uint64(math.MaxUint64), wantOutput: "i18446744073709551615e"},
+	{name: "large uint64", in: uint64(1 << 63), wantOutput: "i9223372036854775808e"},
+	{name: "uintptr", in: uintptr(123), wantOutput: "i123e"},
+	{name: "uint64 list", in: []uint64{math.MaxUint64, 1}, wantOutput: "li18446744073709551615ei1ee"},
+
+	{name: "zero big int", in: big.NewInt(0), wantOutput: "i0e"},
+	{name: "big int", in: func() *big.Int { i, _ := new(big.Int).SetString("123456789012345678901234567890", 10); return i }(),
+		wantOutput: "i123456789012345678901234567890e"},
+	{name: "negative big int", in: func() *big.Int { i, _ := new(big.Int).SetString("-123456789012345678901234567890", 10); return i }(),
+		wantOutput: "i-123456789012345678901234567890e"},
+	{name: "big int value", in: *big.NewInt(-5), wantOutput: "i-5e"},
+	{name: "nil big int", in: (*big.Int)(nil), wantErr: "cannot marshal nil pointer of type *big.Int"},
+	{name: "big int fields",
+		in: struct {
+			Pointer *big.Int `bencode:"pointer"`
+			Value   big.Int  `bencode:"value"`
+		}{
+			Pointer: big.NewInt(math.MaxInt64),
+			Value:   *big.NewInt(math.MinInt64),
+		},
+		wantOutput: "d7:pointeri9223372036854775807e5:valuei-9223372036854775808ee"},
 
 	{name: "empty string", in: "", wantOutput: "0:"},
 	{name: "string", in: "hello", wantOutput: "5:hello"},
 	{name: "string with space", in: "Hello, world!", wantOutput: "13:Hello, world!"},
-	{name: "string with non-ascii characters", in: "§", wantErr: "strings may not contain non-ascii characters: §"},
+	{name: "string with non-ascii characters", in: "§", wantOutput: "2:§"},
+	{name: "string with binary data", in: "\x00\xff\x80", wantOutput: "3:\x00\xff\x80"},
+
+	{name: "nil byte slice", in: []byte(nil), wantOutput: "0:"},
+	{name: "byte slice", in: []byte("hello"), wantOutput: "5:hello"},
+	{name: "binary byte slice", in: []byte{0x00, 0xff, 0x13, 0x80}, wantOutput: "4:\x00\xff\x13\x80"},
+	{name: "empty byte array", in: [0]byte{}, wantOutput: "0:"},
+	{name: "byte array", in: [4]byte{'a', 0xfe, 'b', 0x00}, wantOutput: "4:a\xfeb\x00"},
+	{name: "byte slice list", in: [][]byte{[]byte("ab"), {0xff}}, wantOutput: "l2:ab1:\xffe"},
 
 	{name: "empty array", in: [0]string{}, wantOutput: "le"},
 	{name: "string array", in: [3]string{"a", "bcd", "efghi"}, wantOutput: "l1:a3:bcd5:efghie"},
@@ -60,26 +172,130 @@ var encodeTests = []struct {
 	{name: "int slice", in: []int{1, 234, 5678}, wantOutput: "li1ei234ei5678ee"},
 	{name: "mixed-type slice", in: []interface{}{123, "abc", 456, "def"}, wantOutput: "li123e3:abci456e3:defe"},
 
+	{name: "nil map", in: map[string]int(nil), wantOutput: "de"},
+	{name: "empty map", in: map[string]int{}, wantOutput: "de"},
+	{name: "int map", in: map[string]int{"b": 2, "a": 1, "c": 3}, wantOutput: "d1:ai1e1:bi2e1:ci3ee"},
+	{name: "mixed-type map", in: map[string]interface{}{"list": []int{1}, "int": 2, "string": "x"},
+		wantOutput: "d3:inti2e4:listli1ee6:string1:xe"},
+	{name: "nested map", in: map[string]map[string]string{"outer": {"inner": "x"}},
+		wantOutput: "d5:outerd5:inner1:xee"},
+	{name: "byte-ordered map keys", in: map[string]int{"b": 2, "B": 1, "ab": 3, "a": 4, "": 5},
+		wantOutput: "d0:i5e1:Bi1e1:ai4e2:abi3e1:bi2ee"},
+	{name: "string-kinded map keys", in: map[stringKind]int{"b": 2, "a": 1},
+		wantOutput: "d1:ai1e1:bi2ee"},
+	{name: "non-string map keys", in: map[int]string{1: "a"},
+		wantErr: "encountered unsupported type: map[int]string"},
+	{name: "unsupported map value", in: map[string]interface{}{"a": 1, "b": 2.5},
+		wantErr: "encountered unsupported type: float64"},
+
+	{name: "bool", in: true, wantErr: "encountered unsupported type: bool"},
+	{name: "float32", in: float32(1.5), wantErr: "encountered unsupported type: float32"},
+	{name: "complex", in: complex(1, 2), wantErr: "encountered unsupported type: complex128"},
+	{name: "channel", in: make(chan int), wantErr: "encountered unsupported type: chan int"},
+	{name: "function", in: func() {}, wantErr: "encountered unsupported type: func()"},
+	{name: "unsafe pointer", in: unsafe.Pointer(nil), wantErr: "encountered unsupported type: unsafe.Pointer"},
+	{name: "nil", in: nil, wantErr: "cannot marshal nil"},
+	{name: "nil interface in list", in: []interface{}{1, nil}, wantErr: "cannot marshal nil interface of type interface {}"},
+	{name: "unsupported list element", in: []interface{}{1, 2.5}, wantErr: "encountered unsupported type: float64"},
+	{name: "unsupported array element", in: [2]bool{}, wantErr: "encountered unsupported type: bool"},
+	{name: "unsupported struct field",
+		in: struct {
+			A int  `bencode:"a"`
+			B bool `bencode:"b"`
+			C int  `bencode:"c"`
+		}{A: 1, C: 3},
+		wantErr: "encountered unsupported type: bool"},
+	{name: "unsupported nested struct field",
+		in: struct {
+			Outer map[string][]float64 `bencode:"outer"`
+		}{Outer: map[string][]float64{"inner": {1.5}}},
+		wantErr: "encountered unsupported type: float64"},
+	{name: "unsupported promoted field",
+		in: struct {
+			unsupportedEmbedded
+			A int `bencode:"a"`
+		}{},
+		wantErr: "encountered unsupported type: map[bool]string"},
+	{name: "unsupported type behind pointer", in: func() *float64 { f := 1.5; return &f }(),
+		wantErr: "encountered unsupported type: float64"},
+
+	{name: "pointer to int", in: func() *int { i := 5; return &i }(), wantOutput: "i5e"},
+	{name: "pointer to pointer", in: func() **string { s := "abc"; p := &s; return &p }(), wantOutput: "3:abc"},
+	{name: "nil pointer", in: (*int)(nil), wantErr: "cannot marshal nil pointer of type *int"},
+	{name: "pointer list", in: []*int{func() *int { i := 5; return &i }()}, wantOutput: "li5ee"},
+	{name: "nil pointer in list", in: []*int{nil}, wantErr: "cannot marshal nil pointer of type *int"},
+	{name: "nil values in map", in: map[string]interface{}{"a": 1, "b": nil, "c": (*int)(nil)},
+		wantOutput: "d1:ai1ee"},
+	{name: "pointer fields",
+		in: struct {
+			Int    *int64           `bencode:"int"`
+			Slice  *[]string        `bencode:"slice"`
+			Struct *mapStruct       `bencode:"struct"`
+			Map    *map[string]int  `bencode:"map"`
+			Nested **compositStruct `bencode:"nested"`
+		}{
+			Int:    func() *int64 { i := int64(-1); return &i }(),
+			Slice:  &[]string{"a"},
+			Struct: &mapStruct{Files: map[string]int64{"a": 1}},
+			Map:    &map[string]int{"k": 1},
+			Nested: func() **compositStruct { c := &compositStruct{}; return &c }(),
+		},
+		wantOutput: "d3:inti-1e3:mapd1:ki1ee6:nestedd4:intsle7:stringsle7:structslee5:slicel1:ae6:structd5:filesd1:ai1eeee"},
+	{name: "nil pointer fields",
+		in: struct {
+			Int    *int64        `bencode:"int"`
+			Slice  *[]string     `bencode:"slice"`
+			Struct *simpleStruct `bencode:"struct"`
+			Set    *string       `bencode:"set"`
+		}{
+			Set: func() *string { s := "x"; return &s }(),
+		},
+		wantOutput: "d3:set1:xe"},
+
+	{name: "value marshaler", in: lengthMarshaler("hello"), wantOutput: "i5e"},
+	{name: "pointer to value marshaler", in: func() *lengthMarshaler { m := lengthMarshaler("abc"); return &m }(),
+		wantOutput: "i3e"},
+	{name: "pointer marshaler", in: &pointerMarshaler{n: 7}, wantOutput: "li7ee"},
+	{name: "nil pointer marshaler", in: (*pointerMarshaler)(nil),
+		wantErr: "cannot marshal nil pointer of type *bencode.pointerMarshaler"},
+	{name: "marshaler list", in: []lengthMarshaler{"a", "bb"}, wantOutput: "li1ei2ee"},
+	{name: "addressable pointer marshaler list", in: []pointerMarshaler{{n: 1}, {n: 2}}, wantOutput: "lli1eeli2eee"},
+	{name: "marshaler map", in: map[string]interface{}{"a": lengthMarshaler("xyz"), "b": &pointerMarshaler{n: 1}},
+		wantOutput: "d1:ai3e1:bli1eee"},
+	{name: "marshaler struct fields",
+		in: struct {
+			Length  lengthMarshaler   `bencode:"length"`
+			Pointer *pointerMarshaler `bencode:"pointer"`
+		}{
+			Length:  "abcd",
+			Pointer: &pointerMarshaler{n: 3},
+		},
+		wantOutput: "d6:lengthi4e7:pointerli3eee"},
+	{name: "invalid marshaler output", in: []interface{}{1, invalidMarshaler{}},
+		wantErr: "MarshalBencode for bencode.invalidMarshaler returned invalid Bencode: trailing data at offset 3 cannot be parsed"},
+	{name: "failing marshaler", in: map[string]interface{}{"a": failingMarshaler{}},
+		wantErr: "error calling MarshalBencode for bencode.failingMarshaler: no can do"},
+
 	{name: "empty struct", in: struct{}{}, wantOutput: "de"},
 
 	{name: "single-field struct",
 		in: struct {
-			x string `bencode:"my-field"`
+			X string `bencode:"my-field"`
 		}{
-			x: "hello",
+			X: "hello",
 		},
 		wantOutput: "d8:my-field5:helloe"},
 
 	{
 		name: "multi-field struct",
 		in: struct {
-			x string `bencode:"my-field-1"`
-			y string `bencode:"my-field-2"`
-			z int    `bencode:"my-field-3"`
+			X string `bencode:"my-field-1"`
+			Y string `bencode:"my-field-2"`
+			Z int    `bencode:"my-field-3"`
 		}{
-			x: "hello",
-			y: "world",
-			z: 123,
+			X: "hello",
+			Y: "world",
+			Z: 123,
 		},
 		wantOutput: "d10:my-field-15:hello10:my-field-25:world10:my-field-3i123ee",
 	},
@@ -87,31 +303,107 @@ var encodeTests = []struct {
 	{
 		name: "missing tag struct",
 		in: struct {
-			x string
+			X string
 		}{
-			x: "hello",
+			X: "hello",
 		},
-		wantErr: "found struct field with no 'bencode' tag",
+		wantOutput: "d1:X5:helloe",
 	},
 
 	{
 		name: "incorrect tag struct",
 		in: struct {
-			x string `bad-tag-name:"my-field"`
+			X string `bad-tag-name:"my-field"`
+		}{
+			X: "hello",
+		},
+		wantOutput: "d1:X5:helloe",
+	},
+
+	{
+		name: "unexported fields struct",
+		in: struct {
+			x string `bencode:"x"`
+			y string
+			Z string `bencode:"z"`
 		}{
 			x: "hello",
+			y: "world",
+			Z: "!",
+		},
+		wantOutput: "d1:z1:!e",
+	},
+
+	{
+		name: "ignored fields struct",
+		in: struct {
+			Ignored string `bencode:"-"`
+			Dash    string `bencode:"-,"`
+		}{
+			Ignored: "hello",
+			Dash:    "world",
+		},
+		wantOutput: "d1:-5:worlde",
+	},
+
+	{
+		name: "omitempty struct with empty values",
+		in: struct {
+			Int     int               `bencode:"int,omitempty"`
+			Uint    uint              `bencode:"uint,omitempty"`
+			String  string            `bencode:"string,omitempty"`
+			Bytes   []byte            `bencode:"bytes,omitempty"`
+			Slice   []int             `bencode:"slice,omitempty"`
+			Array   [0]int            `bencode:"array,omitempty"`
+			Map     map[string]int    `bencode:"map,omitempty"`
+			Pointer *int              `bencode:"pointer,omitempty"`
+			Struct  struct{}          `bencode:"struct,omitempty"`
+			Kept    string            `bencode:"kept"`
+			NoKey   map[string]string `bencode:",omitempty"`
+		}{},
+		wantOutput: "d4:kept0:6:structdee",
+	},
+
+	{
+		name: "omitempty struct with non-empty values",
+		in: struct {
+			Int    int            `bencode:"int,omitempty"`
+			String string         `bencode:"string,omitempty"`
+			Slice  []int          `bencode:"slice,omitempty"`
+			Map    map[string]int `bencode:"map,omitempty"`
+			NoKey  string         `bencode:",omitempty"`
+		}{
+			Int:    -1,
+			String: "a",
+			Slice:  []int{0},
+			Map:    map[string]int{"b": 0},
+			NoKey:  "c",
+		},
+		wantOutput: "d5:NoKey1:c3:inti-1e3:mapd1:bi0ee5:sliceli0ee6:string1:ae",
+	},
+
+	{
+		name: "conflicting keys struct",
+		in: struct {
+			A string `bencode:"key"`
+			B string `bencode:"key"`
+			C string `bencode:"other"`
+		}{
+			A: "a",
+			B: "b",
+			C: "c",
 		},
-		wantErr: "found struct field with no 'bencode' tag",
+		wantOutput: "d5:other1:ce",
 	},
 
 	{
 		name: "list-containing struct",
 		in: struct {
-			stringArray [3]string `bencode:"string-array"`
-			stringSlice []string  `bencode:"string-slice"`
+			StringArray [3]string `bencode:"string-array"`
+			StringSlice []string  `bencode:"string-slice"`
 		}{
-			stringArray: [3]string{"a", "b", "c"},
-			stringSlice: []string{"x", "y", "z"},
+			StringArray: [3]string{"a", "b", "c"},
+			StringSlice: []string{"x", "y", "z"},
 		},
 		wantOutput: "d12:string-arrayl1:a1:b1:ce12:string-slicel1:x1:y1:zee",
 	},
@@ -119,46 +411,131 @@ var encodeTests = []struct {
 	{
 		name: "struct-containing struct",
 		in: struct {
-			structField struct {
-				a int `bencode:"a"`
-				b int `bencode:"b"`
+			StructField struct {
+				A int `bencode:"a"`
+				B int `bencode:"b"`
 			} `bencode:"struct"`
-			structArray [3]struct {
-				c int `bencode:"c"`
+			StructArray [3]struct {
+				C int `bencode:"c"`
 			} `bencode:"struct-array"`
-			structSlice []struct {
-				d int `bencode:"d"`
+			StructSlice []struct {
+				D int `bencode:"d"`
 			} `bencode:"struct-slice"`
 		}{
-			structField: struct {
-				a int `bencode:"a"`
-				b int `bencode:"b"`
+			StructField: struct {
+				A int `bencode:"a"`
+				B int `bencode:"b"`
 			}{
-				a: 123,
-				b: 456,
+				A: 123,
+				B: 456,
 			},
-			structArray: [3]struct {
-				c int `bencode:"c"`
-			}{{c: 1}, {c: 2}, {c: 3}},
-			structSlice: []struct {
-				d int `bencode:"d"`
-			}{{d: 1}, {d: 2}, {d: 3}},
+			StructArray: [3]struct {
+				C int `bencode:"c"`
+			}{{C: 1}, {C: 2}, {C: 3}},
+			StructSlice: []struct {
+				D int `bencode:"d"`
+			}{{D: 1}, {D: 2}, {D: 3}},
 		},
 		wantOutput: "d6:structd1:ai123e1:bi456ee12:struct-arrayld1:ci1eed1:ci2eed1:ci3eee12:struct-sliceld1:di1eed1:di2eed1:di3eeee",
 	},
 
+	{
+		name: "byte-containing struct",
+		in: struct {
+			Name   string  `bencode:"name"`
+			Pieces []byte  `bencode:"pieces"`
+			Hash   [4]byte `bencode:"hash"`
+		}{
+			Name:   "ファイル.txt",
+			Pieces: []byte{0xde, 0xad, 0xbe, 0xef, 0x00},
+			Hash:   [4]byte{0x01, 0x02, 0x03, 0x04},
+		},
+		wantOutput: "d4:hash4:\x01\x02\x03\x044:name16:ファイル.txt6:pieces5:\xde\xad\xbe\xef\x00e",
+	},
+
+	{
+		name: "embedded struct",
+		in: metainfoV1{
+			MetainfoBase: MetainfoBase{Announce: "url", Comment: "hi"},
+			Info: struct {
+				Name string `bencode:"name"`
+			}{Name: "a.txt"},
+		},
+		wantOutput: "d8:announce3:url7:comment2:hi4:infod4:name5:a.txtee",
+	},
+
+	{
+		name: "embedded pointer with shadowed field",
+		in: metainfoV2{
+			MetainfoBase: &MetainfoBase{Announce: "url", CreationDate: 1, Comment: "hidden"},
+			Comment:      "shown",
+			Version:      2,
+		},
+		wantOutput: "d8:announce3:url7:comment5:shown13:creation datei1e12:meta versioni2ee",
+	},
+
+	{
+		name:       "nil embedded pointer",
+		in:         metainfoV2{Comment: "shown", Version: 2},
+		wantOutput: "d7:comment5:shown12:meta versioni2ee",
+	},
+
+	{
+		name: "unexported embedded struct",
+		in: struct {
+			unexportedBase
+		}{
+			unexportedBase{Exported: "a", unexported: "b"},
+		},
+		wantOutput: "d8:exported1:ae",
+	},
+
+	{
+		name: "tagged embedded struct",
+		in: struct {
+			MetainfoBase `bencode:"base"`
+		}{
+			MetainfoBase{Announce: "url"},
+		},
+		wantOutput: "d4:based8:announce3:urlee",
+	},
+
+	{
+		name: "conflicting embedded fields",
+		in: struct {
+			MetainfoBase
+			OtherBase
+		}{
+			MetainfoBase{Announce: "a", Comment: "c"},
+			OtherBase{Announce: "b", Extra: "e"},
+		},
+		wantOutput: "d7:comment1:c5:extra1:ee",
+	},
+
+	{
+		name: "tagged field dominates untagged embedded field",
+		in: struct {
+			Embedded1
+			Embedded2
+		}{
+			Embedded1{Key: "untagged"},
+			Embedded2{Key: "tagged"},
+		},
+		wantOutput: "d3:Key6:taggede",
+	},
+
 	{
 		name: "bencode sorting in struct",
 		in: struct {
-			c    string `bencode:"c"`
-			b    string `bencode:"b"`
-			a    string `bencode:"a"`
-			zero string `bencode:"0"`
+			C    string `bencode:"c"`
+			B    string `bencode:"b"`
+			A    string `bencode:"a"`
+			Zero string `bencode:"0"`
 		}{
-			c:    "C",
-			b:    "B",
-			a:    "A",
-			zero: "ZERO",
+			C:    "C",
+			B:    "B",
+			A:    "A",
+			Zero: "ZERO",
 		},
 		wantOutput: "d1:04:ZERO1:a1:A1:b1:B1:c1:Ce",
 	},
@@ -185,3 +562,113 @@ func TestEncode(t *testing.T) {
 		})
 	}
 }
+
+type benchmarkPeer struct {
+	ID   []byte `bencode:"peer id"`
+	IP   string `bencode:"ip"`
+	Port uint16 `bencode:"port"`
+}
+
+type benchmarkAnnounce struct {
+	MetainfoBase
+	Complete    int64           `bencode:"complete"`
+	Incomplete  int64           `bencode:"incomplete"`
+	Interval    int64           `bencode:"interval"`
+	MinInterval int64           `bencode:"min interval,omitempty"`
+	TrackerID   string          `bencode:"tracker id,omitempty"`
+	Warning     string          `bencode:"warning message,omitempty"`
+	Peers       []benchmarkPeer `bencode:"peers"`
+}
+
+func newBenchmarkAnnounce() benchmarkAnnounce {
+	announce := benchmarkAnnounce{
+		MetainfoBase: MetainfoBase{Announce: "http://tracker.example.com/announce"},
+		Complete:     120,
+		Incomplete:   14,
+		Interval:     1800,
+	}
+	for i := 0; i < 50; i++ {
+		announce.Peers = append(announce.Peers, benchmarkPeer{
+			ID:   []byte("-XX0001-0123456789ab"),
+			IP:   "192.168.0." + strconv.Itoa(i),
+			Port: uint16(6881 + i),
+		})
+	}
+	return announce
+}
+
+func TestEncodeDeeplyNested(t *testing.T) {
+	var v interface{} = []interface{}{}
+	for i := 0; i < DefaultMaxDepth; i++ {
+		v = []interface{}{v}
+	}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1); string(out) != want {
+		t.Errorf("got output of length %d, want length %d", len(out), len(want))
+	}
+}
+
+type cycleNode struct {
+	Next *cycleNode `bencode:"next"`
+}
+
+func TestEncodeCycle(t *testing.T) {
+	node := &cycleNode{}
+	node.Next = node
+	m := map[string]interface{}{}
+	m["m"] = m
+	s := []interface{}{nil}
+	s[0] = s
+
+	testCases := []struct {
+		name    string
+		in      interface{}
+		wantErr string
+	}{
+		{name: "pointer", in: node,
+			wantErr: "cannot marshal value of type *bencode.cycleNode: encountered a cycle"},
+		{name: "map", in: m,
+			wantErr: "cannot marshal value of type map[string]interface {}: encountered a cycle"},
+		{name: "slice", in: s,
+			wantErr: "cannot marshal value of type []interface {}: encountered a cycle"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := Marshal(testCase.in)
+			var valueErr *UnsupportedValueError
+			if !errors.As(err, &valueErr) || err.Error() != testCase.wantErr {
+				t.Errorf("got error '%v', want *UnsupportedValueError '%v'", err, testCase.wantErr)
+			}
+
+			err = NewEncoder(ioutil.Discard).Encode(testCase.in)
+			if !errors.As(err, &valueErr) || err.Error() != testCase.wantErr {
+				t.Errorf("got error '%v' from Encoder, want *UnsupportedValueError '%v'", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeSharedPointers(t *testing.T) {
+	// A value reached twice without a cycle is encoded each time.
+	shared := &cycleNode{}
+	var v interface{} = []*cycleNode{shared, shared}
+	for i := 0; i < startDetectingCyclesAfter; i++ {
+		v = []interface{}{v}
+	}
+	if _, err := Marshal(v); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	announce := newBenchmarkAnnounce()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(announce); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A SyntaxError describes input that is not valid Bencode, or, when decoding
+// with DecoderOptions.Strict, not in canonical form.
+type SyntaxError struct {
+	msg string
+
+	// Offset is the position in the input at which the error was found.
+	Offset int
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg
+}
+
+// An UnmarshalTypeError describes a Bencode value that cannot be stored in
+// the Go value it is decoded into.
+type UnmarshalTypeError struct {
+	// Value describes the Bencode value, such as "list" or "integer 300".
+	Value string
+
+	// Type is the type of the Go value that could not hold it.
+	Type reflect.Type
+
+	// Offset is the position of the Bencode value in the input.
+	Offset int
+
+	// Field is the path from the top-level value to the one that could not
+	// be decoded, made of dictionary keys and list indices, such as
+	// "info.files[3].length". It is empty for the top-level value itself.
+	Field string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("cannot unmarshal %s at offset %d into %s", e.Value, e.Offset, e.Type)
+	}
+	return fmt.Sprintf("cannot unmarshal %s at offset %d into field %s of type %s", e.Value, e.Offset, e.Field, e.Type)
+}
+
+// Errors wrapped by LimitError, one for each of the limits in DecoderOptions.
+var (
+	ErrMaxDepth        = errors.New("maximum nesting depth exceeded")
+	ErrMaxStringLength = errors.New("maximum string length exceeded")
+	ErrMaxElements     = errors.New("maximum number of elements exceeded")
+	ErrMaxInputSize    = errors.New("maximum input size exceeded")
+)
+
+// A LimitError describes input that exceeds one of the limits set in
+// DecoderOptions. Use errors.Is to find out which one.
+type LimitError struct {
+	// Err is one of ErrMaxDepth, ErrMaxStringLength, ErrMaxElements, or
+	// ErrMaxInputSize.
+	Err error
+
+	// Limit is the value of the limit that was exceeded.
+	Limit int
+
+	// Offset is the position in the input at which the limit was exceeded.
+	Offset int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// An InvalidUnmarshalError describes an invalid argument passed to Unmarshal
+// or Decoder.Decode. The argument must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "v is not a non-nil pointer: nil"
+	}
+	return "v is not a non-nil pointer: " + e.Type.String()
+}
+
+// An UnsupportedTypeError is returned by Marshal when asked to encode a value
+// of a type that has no Bencode representation.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "encountered unsupported type: " + e.Type.String()
+}
+
+// An UnsupportedValueError is returned by Marshal when asked to encode a value
+// that has no Bencode representation, such as a nil pointer outside of a
+// dictionary.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "cannot marshal " + e.Str
+}
+
+// pathElem is one step of the path to the value being decoded: either a
+// dictionary key or, if index is not negative, a list index.
+type pathElem struct {
+	key   []byte
+	index int
+}
+
+// fieldPath formats path the way UnmarshalTypeError.Field describes.
+func fieldPath(path []pathElem) string {
+	var b strings.Builder
+	for i, elem := range path {
+		if elem.index >= 0 {
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(elem.index))
+			b.WriteByte(']')
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.Write(elem.key)
+	}
+	return b.String()
+}
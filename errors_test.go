@@ -0,0 +1,123 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type errorFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type errorInfo struct {
+	Files []errorFile `bencode:"files"`
+}
+
+type errorTorrent struct {
+	Info   errorInfo                   `bencode:"info"`
+	Extras map[string][]map[string]int `bencode:"extras"`
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		in        string
+		outputArg interface{}
+		want      UnmarshalTypeError
+	}{
+		{name: "top-level value", in: "3:abc", outputArg: int64(0),
+			want: UnmarshalTypeError{Value: "string", Type: reflect.TypeOf(int64(0)), Offset: 0}},
+		{name: "struct field in list", in: "d4:infod5:filesld6:lengthi1eed6:length1:xeeee", outputArg: errorTorrent{},
+			want: UnmarshalTypeError{Value: "string", Type: reflect.TypeOf(int64(0)), Offset: 38, Field: "info.files[1].length"}},
+		{name: "list element", in: "d4:infod5:filesld4:pathl1:ai1eeeeee", outputArg: errorTorrent{},
+			want: UnmarshalTypeError{Value: "integer", Type: reflect.TypeOf(""), Offset: 27, Field: "info.files[0].path[1]"}},
+		{name: "map values", in: "d6:extrasd1:xld1:yi1e1:zleeeee", outputArg: errorTorrent{},
+			want: UnmarshalTypeError{Value: "list", Type: reflect.TypeOf(0), Offset: 24, Field: "extras.x[0].z"}},
+		{name: "overflow", in: "li1ei300ee", outputArg: []uint8{},
+			want: UnmarshalTypeError{Value: "integer 300", Type: reflect.TypeOf(uint8(0)), Offset: 4, Field: "[1]"}},
+		{name: "byte array length", in: "d1:x2:abe", outputArg: map[string][3]byte{},
+			want: UnmarshalTypeError{Value: "string of length 2", Type: reflect.TypeOf([3]byte{}), Offset: 4, Field: "x"}},
+		{name: "skipped values are not part of the path", in: "d1:ali1ee4:infod5:filesi1eee", outputArg: errorTorrent{},
+			want: UnmarshalTypeError{Value: "integer", Type: reflect.TypeOf([]errorFile{}), Offset: 23, Field: "info.files"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := Unmarshal([]byte(testCase.in), reflect.New(reflect.TypeOf(testCase.outputArg)).Interface())
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("got error '%v', want an *UnmarshalTypeError", err)
+			}
+			if !reflect.DeepEqual(*typeErr, testCase.want) {
+				t.Errorf("got error %+v, want %+v", *typeErr, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		opts       DecoderOptions
+		wantOffset int
+	}{
+		{name: "bad start", in: "li1ex", wantOffset: 4},
+		{name: "trailing data", in: "i1ei2e", wantOffset: 3},
+		{name: "missing terminator", in: "d1:xi1e", wantOffset: 7},
+		{name: "non-canonical integer", in: "li1ei01ee", opts: DecoderOptions{Strict: true}, wantOffset: 5},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var v interface{}
+			err := testCase.opts.Unmarshal([]byte(testCase.in), &v)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got error '%v', want a *SyntaxError", err)
+			}
+			if syntaxErr.Offset != testCase.wantOffset {
+				t.Errorf("got offset %d, want %d", syntaxErr.Offset, testCase.wantOffset)
+			}
+		})
+	}
+}
+
+func TestInvalidUnmarshalError(t *testing.T) {
+	for _, v := range []interface{}{nil, int64(0), (*int64)(nil)} {
+		t.Run(fmt.Sprintf("%T", v), func(t *testing.T) {
+			err := Unmarshal([]byte("i1e"), v)
+			var invalidErr *InvalidUnmarshalError
+			if !errors.As(err, &invalidErr) {
+				t.Fatalf("got error '%v', want an *InvalidUnmarshalError", err)
+			}
+			if invalidErr.Type != reflect.TypeOf(v) {
+				t.Errorf("got type %v, want %v", invalidErr.Type, reflect.TypeOf(v))
+			}
+		})
+	}
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	for _, v := range []interface{}{1.5, map[int]string{1: "a"}, []interface{}{make(chan int)}} {
+		t.Run(fmt.Sprintf("%T", v), func(t *testing.T) {
+			_, err := Marshal(v)
+			var unsupportedErr *UnsupportedTypeError
+			if !errors.As(err, &unsupportedErr) {
+				t.Fatalf("got error '%v', want an *UnsupportedTypeError", err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedValueError(t *testing.T) {
+	_, err := Marshal([]*int64{nil})
+	var unsupportedErr *UnsupportedValueError
+	if !errors.As(err, &unsupportedErr) {
+		t.Fatalf("got error '%v', want an *UnsupportedValueError", err)
+	}
+	if want := "cannot marshal nil pointer of type *int64"; err.Error() != want {
+		t.Errorf("got error '%v', want '%v'", err, want)
+	}
+}
@@ -0,0 +1,249 @@
+package bencode
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field describes how a struct field is represented in a Bencode dictionary.
+type field struct {
+	// key is the dictionary key for the field.
+	key string
+
+	// index is the sequence of field indices leading to the field. It has
+	// more than one element for fields promoted from embedded structs.
+	index []int
+
+	// typ is the type of the field, or the struct type for embedded structs
+	// that are yet to be explored.
+	typ reflect.Type
+
+	// tagged is set if the key comes from a struct tag.
+	tagged bool
+
+	// omitEmpty is set for fields tagged with the "omitempty" option, which
+	// are left out of the output when they hold an empty value.
+	omitEmpty bool
+}
+
+// structPlan is the compiled description of how a struct type is encoded and
+// decoded. Plans are computed once per type and shared between calls.
+type structPlan struct {
+	// fields holds the fields in key order, which is the order in which
+	// they are encoded.
+	fields []field
+
+	// byKey maps each key to its position in fields.
+	byKey map[string]int
+}
+
+// field returns the field stored under key. A nil plan, which stands for a
+// value that is being skipped, has no fields.
+func (p *structPlan) field(key string) (field, bool) {
+	if p == nil {
+		return field{}, false
+	}
+	i, ok := p.byKey[key]
+	if !ok {
+		return field{}, false
+	}
+	return p.fields[i], true
+}
+
+// planCache maps each struct type to its *structPlan.
+var planCache sync.Map
+
+// cachedStructPlan returns the plan for the struct type t, computing it on
+// first use.
+func cachedStructPlan(t reflect.Type) *structPlan {
+	if plan, ok := planCache.Load(t); ok {
+		return plan.(*structPlan)
+	}
+	fields := structFields(t)
+	byKey := make(map[string]int, len(fields))
+	for i, f := range fields {
+		byKey[f.key] = i
+	}
+	plan, _ := planCache.LoadOrStore(t, &structPlan{fields: fields, byKey: byKey})
+	return plan.(*structPlan)
+}
+
+// structFields returns the fields of the struct type t that are encoded and
+// decoded, ordered by key. The rules follow encoding/json: the "bencode" tag
+// of a field supplies its key, followed by comma-separated options. A field
+// tagged "-" is ignored, as are unexported fields. Exported fields without a
+// key in their tag use the field name as the key.
+//
+// The fields of untagged embedded structs are promoted into t as though they
+// were declared in it. When several fields share a key, Go's visibility
+// rules decide which one is used, except that a tagged field wins over
+// untagged ones at the same depth. If that leaves no single winner, none of
+// the fields are used.
+func structFields(t reflect.Type) []field {
+	// Embedded structs are explored breadth-first, one level at a time, so
+	// that shallower fields are found before the ones they hide.
+	var current []field
+	next := []field{{typ: t}}
+
+	// Number of times each struct type was found at the current and next
+	// levels.
+	var count map[reflect.Type]int
+	nextCount := map[reflect.Type]int{}
+
+	visited := map[reflect.Type]bool{}
+
+	var fields []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				structField := f.typ.Field(i)
+				if structField.Anonymous {
+					embeddedType := structField.Type
+					if embeddedType.Kind() == reflect.Ptr {
+						embeddedType = embeddedType.Elem()
+					}
+					// The exported fields of an unexported embedded struct
+					// are still promoted.
+					if structField.PkgPath != "" && embeddedType.Kind() != reflect.Struct {
+						continue
+					}
+				} else if structField.PkgPath != "" {
+					continue
+				}
+
+				tag := structField.Tag.Get("bencode")
+				if tag == "-" {
+					continue
+				}
+				key, options := parseTag(tag)
+
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				fieldType := structField.Type
+				if fieldType.Name() == "" && fieldType.Kind() == reflect.Ptr {
+					fieldType = fieldType.Elem()
+				}
+
+				if key != "" || !structField.Anonymous || fieldType.Kind() != reflect.Struct {
+					tagged := key != ""
+					if key == "" {
+						key = structField.Name
+					}
+					fields = append(fields, field{
+						key:       key,
+						index:     index,
+						typ:       fieldType,
+						tagged:    tagged,
+						omitEmpty: options.contains("omitempty"),
+					})
+					// If the enclosing struct was embedded more than once at
+					// this level, its fields conflict with one another. A
+					// second copy is enough to make that visible below.
+					if count[f.typ] > 1 {
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				// Explore the embedded struct at the next level.
+				nextCount[fieldType]++
+				if nextCount[fieldType] == 1 {
+					next = append(next, field{index: index, typ: fieldType})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].key != fields[j].key {
+			return fields[i].key < fields[j].key
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+
+	// Keep only the dominant field for each key.
+	out := fields[:0]
+	for i, advance := 0, 0; i < len(fields); i += advance {
+		advance = 1
+		for i+advance < len(fields) && fields[i+advance].key == fields[i].key {
+			advance++
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	return out
+}
+
+// dominantField returns the field that is used for a key, given all of the
+// fields with that key sorted by depth and then tagging. There is no dominant
+// field if the first two are equally shallow and equally tagged.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+// tagOptions is the part of a struct tag that follows the key.
+type tagOptions string
+
+// parseTag splits a struct tag into its key and options.
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// contains reports whether a comma-separated list of options contains the
+// given option.
+func (o tagOptions) contains(option string) bool {
+	for o != "" {
+		var current string
+		if i := strings.Index(string(o), ","); i != -1 {
+			current, o = string(o[:i]), o[i+1:]
+		} else {
+			current, o = string(o), ""
+		}
+		if current == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValue reports whether v is the empty value for the purposes of the
+// "omitempty" option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package bencode
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCachedStructPlan(t *testing.T) {
+	structType := reflect.TypeOf(metainfoV2{})
+	plan := cachedStructPlan(structType)
+	if again := cachedStructPlan(structType); again != plan {
+		t.Errorf("got a new plan for the same type, want the cached plan")
+	}
+
+	var keys []string
+	for _, f := range plan.fields {
+		keys = append(keys, f.key)
+	}
+	if want := []string{"announce", "comment", "creation date", "meta version"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %q, want %q", keys, want)
+	}
+	if f, ok := plan.field("comment"); !ok || !reflect.DeepEqual(f.index, []int{1}) {
+		t.Errorf("got field '%+v' for the shadowing key, want the outer field", f)
+	}
+	if _, ok := plan.field("missing"); ok {
+		t.Errorf("got a field for a missing key")
+	}
+}
+
+func TestCachedStructPlanConcurrentUse(t *testing.T) {
+	type concurrentStruct struct {
+		A int64  `bencode:"a"`
+		B string `bencode:"b"`
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := Marshal(concurrentStruct{A: 1, B: "x"})
+			if err != nil || string(out) != "d1:ai1e1:b1:xe" {
+				t.Errorf("got output '%s' and error '%v', want 'd1:ai1e1:b1:xe'", out, err)
+			}
+			var got concurrentStruct
+			if err := Unmarshal(out, &got); err != nil || got != (concurrentStruct{A: 1, B: "x"}) {
+				t.Errorf("got output '%+v' and error '%v'", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,145 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNotFound is wrapped by the errors that Get and UnmarshalAt return when
+// the path does not lead to a value.
+var ErrNotFound = errors.New("not found")
+
+// Get returns the encoding of the value found by following path from the
+// top-level value in data. Each element of path is either a string or []byte,
+// which looks up a key in a dictionary, or an int, which indexes a list. If a
+// dictionary holds a key more than once, the first occurrence is used.
+//
+// Only the input up to the end of the value is scanned, skipping over the
+// values that are not on the path. Those values are still checked to be
+// well-formed, but the input after the value found is not checked at all.
+//
+// The returned RawMessage aliases data.
+func Get(data []byte, path ...interface{}) (RawMessage, error) {
+	start, limit, err := get(data, path)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage(data[start:limit]), nil
+}
+
+// UnmarshalAt is like Get, but decodes the value found into the value
+// pointed to by v, as Unmarshal does.
+func UnmarshalAt(data []byte, v interface{}, path ...interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	start, limit, err := get(data, path)
+	if err != nil {
+		return err
+	}
+	return unmarshal(data[start:limit], start, &value, DecoderOptions{})
+}
+
+// get returns the span of data that holds the value at path.
+func get(data []byte, path []interface{}) (int, int, error) {
+	d := decoder{
+		data:   data,
+		offset: 0,
+	}
+	for _, elem := range path {
+		var err error
+		switch elem := elem.(type) {
+		case string:
+			err = d.lookupKey([]byte(elem))
+		case []byte:
+			err = d.lookupKey(elem)
+		case int:
+			err = d.lookupIndex(elem)
+		default:
+			err = fmt.Errorf("unsupported path element type: %T", elem)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	start := d.offset
+	if err := d.unmarshalNext(nil); err != nil {
+		return 0, 0, err
+	}
+	return start, d.offset, nil
+}
+
+// checkContainer returns an error unless the value at the current offset
+// starts with the given byte. Malformed input is reported as such.
+func (d *decoder) checkContainer(start byte, name string) error {
+	if d.isDone() {
+		return d.syntaxError(d.offset, "no data to read at offset %d")
+	}
+	switch c := d.data[d.offset]; {
+	case c == start:
+		return nil
+	case isDigit(c) || c == integer || c == list || c == dictionary:
+		return fmt.Errorf("value at offset %d is not a %s: %w", d.base+d.offset, name, ErrNotFound)
+	}
+	return d.unmarshalNext(nil)
+}
+
+// lookupKey moves the offset from the start of a dictionary to the start of
+// the value stored under key.
+func (d *decoder) lookupKey(key []byte) error {
+	if err := d.checkContainer(dictionary, "dictionary"); err != nil {
+		return err
+	}
+	dictStart := d.offset
+
+	d.offset++ // Consume 'd'.
+	for d.offset < len(d.data) && d.data[d.offset] != terminator {
+		if !isDigit(d.data[d.offset]) {
+			return d.syntaxError(d.offset, "dictionary key at offset %d is not a string")
+		}
+		start, limit, err := d.stringIndices(d.offset)
+		if err != nil {
+			return err
+		}
+		d.offset = limit
+		if bytes.Equal(d.data[start:limit], key) {
+			return nil
+		}
+		if err := d.unmarshalNext(nil); err != nil {
+			return err
+		}
+	}
+
+	if d.offset >= len(d.data) {
+		return d.syntaxError(d.offset, "expected terminator for dictionary at offset %d")
+	}
+	return fmt.Errorf("dictionary at offset %d has no key %q: %w", d.base+dictStart, key, ErrNotFound)
+}
+
+// lookupIndex moves the offset from the start of a list to the start of the
+// element with the given index.
+func (d *decoder) lookupIndex(index int) error {
+	if err := d.checkContainer(list, "list"); err != nil {
+		return err
+	}
+	listStart := d.offset
+
+	d.offset++ // Consume 'l'.
+	for i := 0; d.offset < len(d.data) && d.data[d.offset] != terminator; i++ {
+		if i == index {
+			return nil
+		}
+		if err := d.unmarshalNext(nil); err != nil {
+			return err
+		}
+	}
+
+	if d.offset >= len(d.data) {
+		return d.syntaxError(d.offset, "expected terminator for list at offset %d")
+	}
+	return fmt.Errorf("list at offset %d has no index %d: %w", d.base+listStart, index, ErrNotFound)
+}
@@ -0,0 +1,107 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const getTestTorrent = "d8:announce3:url4:infod5:filesld6:lengthi10e4:pathl1:aeed6:lengthi20e4:pathl1:b1:ceee4:name3:dire3:urlli1ei2eee"
+
+var getTests = []struct {
+	name         string
+	in           string
+	path         []interface{}
+	wantErr      string
+	wantNotFound bool
+	wantOutput   string
+}{
+	{name: "empty path", in: "i1e",
+		wantOutput: "i1e"},
+	{name: "top-level key", in: getTestTorrent, path: []interface{}{"announce"},
+		wantOutput: "3:url"},
+	{name: "nested key", in: getTestTorrent, path: []interface{}{"info", "name"},
+		wantOutput: "3:dir"},
+	{name: "dictionary", in: getTestTorrent, path: []interface{}{"info", "files", 0},
+		wantOutput: "d6:lengthi10e4:pathl1:aee"},
+	{name: "list index", in: getTestTorrent, path: []interface{}{"info", "files", 1, "path", 1},
+		wantOutput: "1:c"},
+	{name: "byte slice key", in: getTestTorrent, path: []interface{}{[]byte("url"), 1},
+		wantOutput: "i2e"},
+	{name: "first of duplicate keys", in: "d1:ai1e1:ai2ee", path: []interface{}{"a"},
+		wantOutput: "i1e"},
+	{name: "input after the value is not checked", in: "d1:ai1e1:bxxx", path: []interface{}{"a"},
+		wantOutput: "i1e"},
+
+	{name: "missing key", in: getTestTorrent, path: []interface{}{"info", "length"},
+		wantErr:      "dictionary at offset 22 has no key \"length\": not found",
+		wantNotFound: true},
+	{name: "index out of range", in: getTestTorrent, path: []interface{}{"info", "files", 2},
+		wantErr:      "list at offset 30 has no index 2: not found",
+		wantNotFound: true},
+	{name: "negative index", in: "li1ee", path: []interface{}{-1},
+		wantErr:      "list at offset 0 has no index -1: not found",
+		wantNotFound: true},
+	{name: "key in list", in: getTestTorrent, path: []interface{}{"url", "x"},
+		wantErr:      "value at offset 102 is not a dictionary: not found",
+		wantNotFound: true},
+	{name: "index in string", in: getTestTorrent, path: []interface{}{"announce", 0},
+		wantErr:      "value at offset 11 is not a list: not found",
+		wantNotFound: true},
+	{name: "unsupported path element", in: "li1ee", path: []interface{}{1.5},
+		wantErr: "unsupported path element type: float64"},
+
+	{name: "malformed skipped value", in: "d1:ai1x1:bi1ee", path: []interface{}{"b"},
+		wantErr: "expected terminator for integer at offset 6"},
+	{name: "malformed target", in: "d1:ai1xe", path: []interface{}{"a"},
+		wantErr: "expected terminator for integer at offset 6"},
+	{name: "overflowing string length", in: "d1:a9223372036854775807:abce", path: []interface{}{"b"},
+		wantErr: "string at offset 4 has length 9223372036854775807, yet there are not that many bytes left"},
+	{name: "truncated dictionary", in: "d1:ai1e", path: []interface{}{"b"},
+		wantErr: "expected terminator for dictionary at offset 7"},
+	{name: "malformed container", in: "x", path: []interface{}{"a"},
+		wantErr: "expected start of integer, string, list, or dictionary at offset 0"},
+	{name: "empty input", in: "", path: []interface{}{0},
+		wantErr: "no data to read at offset 0"},
+}
+
+func TestGet(t *testing.T) {
+	for _, testCase := range getTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := Get([]byte(testCase.in), testCase.path...)
+			if testCase.wantErr != "" || err != nil {
+				if err == nil {
+					t.Errorf("want error with message '%v', got no error", testCase.wantErr)
+				} else if err.Error() != testCase.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+				}
+			}
+			if errors.Is(err, ErrNotFound) != testCase.wantNotFound {
+				t.Errorf("got error '%v', want errors.Is(err, ErrNotFound) to be %t", err, testCase.wantNotFound)
+			}
+			if string(got) != testCase.wantOutput {
+				t.Errorf("got output '%s', want '%s'", got, testCase.wantOutput)
+			}
+		})
+	}
+}
+
+func TestUnmarshalAt(t *testing.T) {
+	var files []struct {
+		Length int64    `bencode:"length"`
+		Path   []string `bencode:"path"`
+	}
+	if err := UnmarshalAt([]byte(getTestTorrent), &files, "info", "files"); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if len(files) != 2 || files[1].Length != 20 || !reflect.DeepEqual(files[1].Path, []string{"b", "c"}) {
+		t.Errorf("got output '%+v'", files)
+	}
+
+	// Offsets in errors are relative to the start of data.
+	var name int64
+	err := UnmarshalAt([]byte(getTestTorrent), &name, "info", "name")
+	if want := "cannot unmarshal string at offset 91 into int64"; err == nil || err.Error() != want {
+		t.Errorf("got error '%v', want '%v'", err, want)
+	}
+}
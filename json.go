@@ -0,0 +1,543 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ToJSON converts data, which must hold a single Bencode value, into JSON
+// that FromJSON converts back into exactly the same bytes.
+//
+// Integers become JSON numbers, whatever their size, and lists become arrays.
+// Strings that are valid UTF-8 become JSON strings, while other strings
+// become an object of the form {"$hex": "<hexadecimal bytes>"}. Dictionaries
+// become objects with their keys in input order. If a dictionary has a key
+// that is not valid UTF-8, that starts with "$", or that appears more than
+// once, it instead becomes an object of the form {"$dict": [[key, value],
+// ...]}, where each key is a string, a "$hex" object, or a "$str" object.
+//
+// Input that is not canonical is kept as is. An integer with leading zeros,
+// or negative zero, becomes an object of the form {"$int": "007"}, holding
+// its digits. A string whose length has leading zeros becomes an object of
+// the form {"$str": ["03", "abc"]}, holding the length as written and the
+// string, and is always stored in the "$dict" form when it is a key.
+func ToJSON(data []byte) ([]byte, error) {
+	d := decoder{
+		data:   data,
+		offset: 0,
+	}
+	var out bytes.Buffer
+	if err := d.walk(&jsonWriter{out: &out}); err != nil {
+		return nil, err
+	}
+	if !d.isDone() {
+		return nil, d.syntaxError(d.offset, "trailing data at offset %d cannot be parsed")
+	}
+	return out.Bytes(), nil
+}
+
+// isCanonicalInt reports whether digits, the text of an integer or a string
+// length, has no leading zeros and no negative sign on zero.
+func isCanonicalInt(digits []byte) bool {
+	if digits[0] == '-' {
+		return digits[1] != '0'
+	}
+	return digits[0] != '0' || len(digits) == 1
+}
+
+// jsonWriter is a visitor that writes the JSON form of the values it is given
+// to out.
+type jsonWriter struct {
+	out *bytes.Buffer
+
+	// containers holds the lists and dictionaries that enclose the current
+	// value.
+	containers []jsonContainer
+}
+
+// jsonContainer is a list or dictionary being written by jsonWriter. The
+// values of a dictionary are written first, and the keys are added around
+// them at its end, once it is known which form the dictionary takes.
+type jsonContainer struct {
+	isDict bool
+
+	// n is the number of elements of a list written so far.
+	n int
+
+	// regionStart is the position in the output of the first value of a
+	// dictionary.
+	regionStart int
+	entries     []jsonEntry
+	seen        map[string]bool
+	plain       bool
+}
+
+// jsonEntry is a dictionary entry whose value has been written to the output.
+type jsonEntry struct {
+	// length is the length of the key as written in the input.
+	length, key []byte
+
+	// start and limit delimit the JSON form of the value in the output.
+	start, limit int
+}
+
+// startValue writes the separator needed before a value.
+func (w *jsonWriter) startValue() {
+	if len(w.containers) == 0 {
+		return
+	}
+	c := &w.containers[len(w.containers)-1]
+	if !c.isDict {
+		if c.n > 0 {
+			w.out.WriteByte(',')
+		}
+		c.n++
+	}
+}
+
+func (w *jsonWriter) Int(digits []byte) error {
+	w.startValue()
+	if isCanonicalInt(digits) {
+		w.out.Write(digits)
+		return nil
+	}
+	w.out.WriteString(`{"$int":"`)
+	w.out.Write(digits)
+	w.out.WriteString(`"}`)
+	return nil
+}
+
+func (w *jsonWriter) String(length, s []byte) error {
+	w.startValue()
+	writeJSONString(w.out, length, s)
+	return nil
+}
+
+func (w *jsonWriter) ListStart() error {
+	w.startValue()
+	w.out.WriteByte('[')
+	w.containers = append(w.containers, jsonContainer{})
+	return nil
+}
+
+func (w *jsonWriter) ListEnd() error {
+	w.containers = w.containers[:len(w.containers)-1]
+	w.out.WriteByte(']')
+	return nil
+}
+
+func (w *jsonWriter) DictStart() error {
+	w.startValue()
+	w.containers = append(w.containers, jsonContainer{
+		isDict:      true,
+		regionStart: w.out.Len(),
+		seen:        map[string]bool{},
+		plain:       true,
+	})
+	return nil
+}
+
+func (w *jsonWriter) Key(length, key []byte, offset int) error {
+	c := &w.containers[len(w.containers)-1]
+	if n := len(c.entries); n > 0 {
+		c.entries[n-1].limit = w.out.Len()
+	}
+	if !isCanonicalInt(length) || !utf8.Valid(key) || bytes.HasPrefix(key, []byte("$")) || c.seen[string(key)] {
+		c.plain = false
+	}
+	c.seen[string(key)] = true
+	c.entries = append(c.entries, jsonEntry{length: length, key: key, start: w.out.Len()})
+	return nil
+}
+
+func (w *jsonWriter) DictEnd() error {
+	c := w.containers[len(w.containers)-1]
+	w.containers = w.containers[:len(w.containers)-1]
+	if n := len(c.entries); n > 0 {
+		c.entries[n-1].limit = w.out.Len()
+	}
+
+	region := append([]byte{}, w.out.Bytes()[c.regionStart:]...)
+	w.out.Truncate(c.regionStart)
+	if c.plain {
+		w.out.WriteByte('{')
+	} else {
+		w.out.WriteString(`{"$dict":[`)
+	}
+	for i, entry := range c.entries {
+		if i > 0 {
+			w.out.WriteByte(',')
+		}
+		if c.plain {
+			writeJSONString(w.out, entry.length, entry.key)
+			w.out.WriteByte(':')
+		} else {
+			w.out.WriteByte('[')
+			writeJSONString(w.out, entry.length, entry.key)
+			w.out.WriteByte(',')
+		}
+		w.out.Write(region[entry.start-c.regionStart : entry.limit-c.regionStart])
+		if !c.plain {
+			w.out.WriteByte(']')
+		}
+	}
+	if c.plain {
+		w.out.WriteByte('}')
+	} else {
+		w.out.WriteString("]}")
+	}
+	return nil
+}
+
+// writeJSONString writes the JSON form of the Bencode string b, whose length
+// is written as length in the input.
+func writeJSONString(out *bytes.Buffer, length, b []byte) {
+	if isCanonicalInt(length) {
+		writeJSONBytes(out, b)
+		return
+	}
+	out.WriteString(`{"$str":["`)
+	out.Write(length)
+	out.WriteString(`",`)
+	writeJSONBytes(out, b)
+	out.WriteString("]}")
+}
+
+// writeJSONBytes writes b as a JSON string, or as a "$hex" object if it is not
+// valid UTF-8.
+func writeJSONBytes(out *bytes.Buffer, b []byte) {
+	if !utf8.Valid(b) {
+		out.WriteString(`{"$hex":"`)
+		out.WriteString(hex.EncodeToString(b))
+		out.WriteString(`"}`)
+		return
+	}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	// Encoding a string cannot fail. Encode adds a newline, which is removed.
+	enc.Encode(string(b))
+	out.Truncate(out.Len() - 1)
+}
+
+// FromJSON converts JSON in the form produced by ToJSON into Bencode. Any JSON
+// that uses only integers, strings, arrays, and objects is accepted, as long
+// as its object keys do not start with "$" except to mark a "$hex", "$str",
+// or "$int" value or a "$dict" dictionary. Dictionary keys are written in the
+// order they appear in, so the output is only canonical if the input's keys
+// are sorted and it has no "$str" or "$int" values.
+func FromJSON(data []byte) ([]byte, error) {
+	r := jsonReader{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	r.dec.UseNumber()
+	var out bytes.Buffer
+	if err := r.convert(&out); err != nil {
+		return nil, err
+	}
+	if _, offset, err := r.next(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data at offset %d cannot be converted", offset)
+	}
+	return out.Bytes(), nil
+}
+
+// jsonReader reads JSON tokens for FromJSON. Nesting is limited by
+// json.Decoder itself.
+type jsonReader struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+// offset returns the offset at which the next token starts.
+func (r *jsonReader) offset() int {
+	offset := int(r.dec.InputOffset())
+	for offset < len(r.data) {
+		if c := r.data[offset]; c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ':' && c != ',' {
+			break
+		}
+		offset++
+	}
+	return offset
+}
+
+// next returns the next token and the offset at which it starts.
+func (r *jsonReader) next() (json.Token, int, error) {
+	offset := r.offset()
+	tok, err := r.dec.Token()
+	return tok, offset, err
+}
+
+// convert converts the next JSON value and writes it to out.
+func (r *jsonReader) convert(out *bytes.Buffer) error {
+	tok, offset, err := r.next()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	switch tok := tok.(type) {
+	case string:
+		marshalString(tok, out)
+		return nil
+
+	case json.Number:
+		if _, ok := new(big.Int).SetString(string(tok), 10); !ok || tok == "-0" {
+			return fmt.Errorf("number %s at offset %d is not an integer that Bencode can represent", tok, offset)
+		}
+		out.WriteByte(integer)
+		out.WriteString(string(tok))
+		out.WriteByte(terminator)
+		return nil
+
+	case json.Delim:
+		if tok == '{' {
+			return r.convertObject(out, offset)
+		}
+		out.WriteByte(list)
+		for r.dec.More() {
+			if err := r.convert(out); err != nil {
+				return err
+			}
+		}
+		if err := r.expectDelim(']', "end of array"); err != nil {
+			return err
+		}
+		out.WriteByte(terminator)
+		return nil
+	}
+	return fmt.Errorf("JSON value %v at offset %d has no Bencode representation", tok, offset)
+}
+
+// convertObject converts a JSON object, starting at the given offset, whose
+// opening brace has been read.
+func (r *jsonReader) convertObject(out *bytes.Buffer, start int) error {
+	dictStart := out.Len()
+	out.WriteByte(dictionary)
+	for i := 0; r.dec.More(); i++ {
+		tok, offset, err := r.next()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if i == 0 && (key == "$hex" || key == "$str" || key == "$int" || key == "$dict") {
+			out.Truncate(dictStart)
+			return r.convertMarker(out, key, start)
+		}
+		if strings.HasPrefix(key, "$") {
+			return fmt.Errorf("object key %q at offset %d is reserved", key, offset)
+		}
+		marshalString(key, out)
+		if err := r.convert(out); err != nil {
+			return err
+		}
+	}
+	if err := r.expectDelim('}', "end of object"); err != nil {
+		return err
+	}
+	out.WriteByte(terminator)
+	return nil
+}
+
+// convertMarker converts the value of a "$hex", "$str", "$int", or "$dict"
+// object, starting at the given offset, whose only key has been read.
+func (r *jsonReader) convertMarker(out *bytes.Buffer, key string, start int) error {
+	var err error
+	switch key {
+	case "$hex":
+		var b []byte
+		if b, err = r.hex(); err == nil {
+			marshalBytes(b, out)
+		}
+	case "$str":
+		err = r.convertStr(out)
+	case "$int":
+		err = r.convertInt(out)
+	default:
+		err = r.convertDict(out)
+	}
+	if err != nil {
+		return err
+	}
+	return r.endMarker(key, start)
+}
+
+// endMarker consumes the end of a marker object, starting at the given
+// offset, which must have no other keys.
+func (r *jsonReader) endMarker(key string, start int) error {
+	tok, _, err := r.next()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('}') {
+		return fmt.Errorf("%s object at offset %d has more than one key", key, start)
+	}
+	return nil
+}
+
+// hex reads the hexadecimal string of a "$hex" object.
+func (r *jsonReader) hex() ([]byte, error) {
+	tok, offset, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := tok.(string)
+	if !ok {
+		return nil, fmt.Errorf("$hex value at offset %d is not a string", offset)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("$hex value at offset %d is not hexadecimal: %w", offset, err)
+	}
+	return b, nil
+}
+
+// readBytes reads a string, which is either a JSON string or a "$hex" object,
+// describing what was expected as what if it is neither.
+func (r *jsonReader) readBytes(what string) ([]byte, error) {
+	tok, offset, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := tok.(string); ok {
+		return []byte(s), nil
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("%s at offset %d is not a string", what, offset)
+	}
+	if tok, _, err := r.next(); err != nil || tok != "$hex" {
+		return nil, fmt.Errorf("%s at offset %d is not a string", what, offset)
+	}
+	b, err := r.hex()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.endMarker("$hex", offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// convertStr converts the length and string of a "$str" object.
+func (r *jsonReader) convertStr(out *bytes.Buffer) error {
+	if err := r.expectDelim('[', "$str value"); err != nil {
+		return err
+	}
+	tok, offset, err := r.next()
+	if err != nil {
+		return err
+	}
+	length, ok := tok.(string)
+	if !ok || length == "" || intLimit(0, []byte(length)) != len(length) {
+		return fmt.Errorf("$str length at offset %d is not a string of digits", offset)
+	}
+	b, err := r.readBytes("$str string")
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(length); err != nil || n != len(b) {
+		return fmt.Errorf("$str length at offset %d does not match the string's length %d", offset, len(b))
+	}
+	if err := r.expectDelim(']', "end of $str value"); err != nil {
+		return err
+	}
+	out.WriteString(length)
+	out.WriteByte(':')
+	out.Write(b)
+	return nil
+}
+
+// convertInt converts the digits of an "$int" object.
+func (r *jsonReader) convertInt(out *bytes.Buffer) error {
+	tok, offset, err := r.next()
+	if err != nil {
+		return err
+	}
+	digits, ok := tok.(string)
+	digitStart := 0
+	if ok && strings.HasPrefix(digits, "-") {
+		digitStart = 1
+	}
+	if !ok || len(digits) == digitStart || intLimit(digitStart, []byte(digits)) != len(digits) {
+		return fmt.Errorf("$int value at offset %d is not a string holding an integer", offset)
+	}
+	out.WriteByte(integer)
+	out.WriteString(digits)
+	out.WriteByte(terminator)
+	return nil
+}
+
+// convertDict converts the [key, value] pairs of a "$dict" object.
+func (r *jsonReader) convertDict(out *bytes.Buffer) error {
+	if err := r.expectDelim('[', "$dict value"); err != nil {
+		return err
+	}
+	out.WriteByte(dictionary)
+	for r.dec.More() {
+		if err := r.expectDelim('[', "$dict entry"); err != nil {
+			return err
+		}
+		if err := r.convertKey(out); err != nil {
+			return err
+		}
+		if !r.dec.More() {
+			return fmt.Errorf("$dict entry at offset %d has no value", r.offset())
+		}
+		if err := r.convert(out); err != nil {
+			return err
+		}
+		if err := r.expectDelim(']', "end of $dict entry"); err != nil {
+			return err
+		}
+	}
+	if err := r.expectDelim(']', "end of $dict value"); err != nil {
+		return err
+	}
+	out.WriteByte(terminator)
+	return nil
+}
+
+// convertKey converts the key of a "$dict" entry, which is a string, a "$hex"
+// object, or a "$str" object.
+func (r *jsonReader) convertKey(out *bytes.Buffer) error {
+	tok, offset, err := r.next()
+	if err != nil {
+		return err
+	}
+	if s, ok := tok.(string); ok {
+		marshalString(s, out)
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("$dict key at offset %d is not a string", offset)
+	}
+	tok, _, err = r.next()
+	if err != nil || (tok != "$hex" && tok != "$str") {
+		return fmt.Errorf("$dict key at offset %d is not a string", offset)
+	}
+	return r.convertMarker(out, tok.(string), offset)
+}
+
+// expectDelim consumes the delimiter want, describing what was expected as
+// what if it is missing.
+func (r *jsonReader) expectDelim(want json.Delim, what string) error {
+	tok, offset, err := r.next()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("expected %s at offset %d", what, offset)
+	}
+	return nil
+}
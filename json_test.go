@@ -0,0 +1,220 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var toJSONTests = []struct {
+	name       string
+	in         string
+	wantErr    string
+	wantOutput string
+}{
+	{name: "integer", in: "i-42e",
+		wantOutput: `-42`},
+	{name: "big integer", in: "i123456789012345678901234567890e",
+		wantOutput: `123456789012345678901234567890`},
+	{name: "string", in: "5:hello",
+		wantOutput: `"hello"`},
+	{name: "empty string", in: "0:",
+		wantOutput: `""`},
+	{name: "escaped string", in: "4:a\"\n<",
+		wantOutput: `"a\"\n<"`},
+	{name: "non-UTF-8 string", in: "2:\xff\x00",
+		wantOutput: `{"$hex":"ff00"}`},
+	{name: "list", in: "li1e1:al0:ee",
+		wantOutput: `[1,"a",[""]]`},
+	{name: "empty list", in: "le",
+		wantOutput: `[]`},
+	{name: "dictionary", in: "d1:ai1e1:bli2eee",
+		wantOutput: `{"a":1,"b":[2]}`},
+	{name: "empty dictionary", in: "de",
+		wantOutput: `{}`},
+	{name: "unsorted keys keep their order", in: "d1:bi1e1:ai2ee",
+		wantOutput: `{"b":1,"a":2}`},
+	{name: "duplicate keys", in: "d1:ai1e1:ai2ee",
+		wantOutput: `{"$dict":[["a",1],["a",2]]}`},
+	{name: "reserved key", in: "d4:$hex2:abe",
+		wantOutput: `{"$dict":[["$hex","ab"]]}`},
+	{name: "non-UTF-8 key", in: "d1:ai1e1:\xffdee",
+		wantOutput: `{"$dict":[["a",1],[{"$hex":"ff"},{}]]}`},
+	{name: "nested special dictionary", in: "ld1:\xff1:\xfeee",
+		wantOutput: `[{"$dict":[[{"$hex":"ff"},{"$hex":"fe"}]]}]`},
+	{name: "integer with leading zero", in: "li01ee",
+		wantOutput: `[{"$int":"01"}]`},
+	{name: "negative integer with leading zeros", in: "i-007e",
+		wantOutput: `{"$int":"-007"}`},
+	{name: "negative zero", in: "i-0e",
+		wantOutput: `{"$int":"-0"}`},
+	{name: "zero with leading zero", in: "i00e",
+		wantOutput: `{"$int":"00"}`},
+	{name: "string length with leading zero", in: "03:abc",
+		wantOutput: `{"$str":["03","abc"]}`},
+	{name: "non-UTF-8 string length with leading zero", in: "02:\xff\x00",
+		wantOutput: `{"$str":["02",{"$hex":"ff00"}]}`},
+	{name: "key length with leading zero", in: "d01:ai1e1:bi2ee",
+		wantOutput: `{"$dict":[[{"$str":["01","a"]},1],["b",2]]}`},
+	{name: "non-canonical input", in: "d1:bi-0e01:\xffli007eee",
+		wantOutput: `{"$dict":[["b",{"$int":"-0"}],[{"$str":["01",{"$hex":"ff"}]},[{"$int":"007"}]]]}`},
+
+	{name: "malformed", in: "li1e",
+		wantErr: "expected terminator for list at offset 4"},
+	{name: "trailing data", in: "i1ei2e",
+		wantErr: "trailing data at offset 3 cannot be parsed"},
+	{name: "empty input", in: "",
+		wantErr: "no data to read at offset 0"},
+}
+
+func TestToJSON(t *testing.T) {
+	for _, testCase := range toJSONTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := ToJSON([]byte(testCase.in))
+			if testCase.wantErr != "" || err != nil {
+				var syntaxErr *SyntaxError
+				if err == nil {
+					t.Errorf("want error with message '%v', got no error", testCase.wantErr)
+				} else if err.Error() != testCase.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+				} else if !errors.As(err, &syntaxErr) {
+					t.Errorf("got error of type %T, want *SyntaxError", err)
+				}
+			}
+			if string(got) != testCase.wantOutput {
+				t.Errorf("got output '%s', want '%s'", got, testCase.wantOutput)
+			}
+			if err != nil {
+				return
+			}
+
+			back, err := FromJSON(got)
+			if err != nil {
+				t.Fatalf("got unexpected error converting back: %v", err)
+			}
+			if !bytes.Equal(back, []byte(testCase.in)) {
+				t.Errorf("got '%s' converting back, want '%s'", back, testCase.in)
+			}
+		})
+	}
+}
+
+var fromJSONTests = []struct {
+	name       string
+	in         string
+	wantErr    string
+	wantOutput string
+}{
+	{name: "whitespace", in: ` { "a" : [ 1 , -2 ] } `,
+		wantOutput: "d1:ali1ei-2eee"},
+	{name: "unicode escape", in: `"é"`,
+		wantOutput: "2:\xc3\xa9"},
+	{name: "hex key in $dict", in: `{"$dict":[[{"$hex":"61"},1]]}`,
+		wantOutput: "d1:ai1ee"},
+	{name: "empty $dict", in: `{"$dict":[]}`,
+		wantOutput: "de"},
+	{name: "canonical $int", in: `{"$int":"5"}`,
+		wantOutput: "i5e"},
+	{name: "$str with empty string", in: `{"$str":["00",""]}`,
+		wantOutput: "00:"},
+
+	{name: "float", in: `1.5`,
+		wantErr: "number 1.5 at offset 0 is not an integer that Bencode can represent"},
+	{name: "exponent", in: `[1e3]`,
+		wantErr: "number 1e3 at offset 1 is not an integer that Bencode can represent"},
+	{name: "negative zero", in: `-0`,
+		wantErr: "number -0 at offset 0 is not an integer that Bencode can represent"},
+	{name: "boolean", in: `[true]`,
+		wantErr: "JSON value true at offset 1 has no Bencode representation"},
+	{name: "null", in: `null`,
+		wantErr: "JSON value <nil> at offset 0 has no Bencode representation"},
+	{name: "reserved key", in: `{"a":1,"$b":2}`,
+		wantErr: `object key "$b" at offset 7 is reserved`},
+	{name: "$hex with another key", in: `{"$hex":"ff","a":1}`,
+		wantErr: "$hex object at offset 0 has more than one key"},
+	{name: "$hex not a string", in: `{"$hex":1}`,
+		wantErr: "$hex value at offset 8 is not a string"},
+	{name: "$hex not hexadecimal", in: `{"$hex":"xy"}`,
+		wantErr: "$hex value at offset 8 is not hexadecimal: encoding/hex: invalid byte: U+0078 'x'"},
+	{name: "$int not a string", in: `{"$int":7}`,
+		wantErr: "$int value at offset 8 is not a string holding an integer"},
+	{name: "$int not an integer", in: `{"$int":"1.5"}`,
+		wantErr: "$int value at offset 8 is not a string holding an integer"},
+	{name: "$int without digits", in: `{"$int":"-"}`,
+		wantErr: "$int value at offset 8 is not a string holding an integer"},
+	{name: "$int with another key", in: `[{"$int":"01","a":1}]`,
+		wantErr: "$int object at offset 1 has more than one key"},
+	{name: "$str not an array", in: `{"$str":"03"}`,
+		wantErr: "expected $str value at offset 8"},
+	{name: "$str length not digits", in: `{"$str":["-3","abc"]}`,
+		wantErr: "$str length at offset 9 is not a string of digits"},
+	{name: "$str length mismatch", in: `{"$str":["04","abc"]}`,
+		wantErr: "$str length at offset 9 does not match the string's length 3"},
+	{name: "$str string not a string", in: `{"$str":["01",1]}`,
+		wantErr: "$str string at offset 14 is not a string"},
+	{name: "$str with extra element", in: `{"$str":["01","a",1]}`,
+		wantErr: "expected end of $str value at offset 18"},
+	{name: "$int key in $dict", in: `{"$dict":[[{"$int":"01"},1]]}`,
+		wantErr: "$dict key at offset 11 is not a string"},
+	{name: "$dict not an array", in: `{"$dict":{}}`,
+		wantErr: "expected $dict value at offset 9"},
+	{name: "$dict entry not an array", in: `{"$dict":[1]}`,
+		wantErr: "expected $dict entry at offset 10"},
+	{name: "$dict entry without value", in: `{"$dict":[["a"]]}`,
+		wantErr: "$dict entry at offset 14 has no value"},
+	{name: "$dict entry with extra element", in: `{"$dict":[["a",1,2]]}`,
+		wantErr: "expected end of $dict entry at offset 17"},
+	{name: "$dict key not a string", in: `{"$dict":[[1,1]]}`,
+		wantErr: "$dict key at offset 11 is not a string"},
+	{name: "trailing data", in: `1 2`,
+		wantErr: "trailing data at offset 2 cannot be converted"},
+	{name: "truncated", in: `[1`,
+		wantErr: "unexpected end of JSON input"},
+	{name: "truncated object", in: `{"a":1`,
+		wantErr: "unexpected end of JSON input"},
+	{name: "truncated $hex", in: `{"$hex":"00"`,
+		wantErr: "unexpected EOF"},
+	{name: "truncated $int", in: `{"$int":"01"`,
+		wantErr: "unexpected EOF"},
+	{name: "truncated $str", in: `{"$str":["01","a"`,
+		wantErr: "unexpected EOF"},
+	{name: "truncated $dict", in: `{"$dict":[["a",1]`,
+		wantErr: "unexpected end of JSON input"},
+	{name: "truncated $hex key", in: `{"$dict":[[{"$hex":"61"`,
+		wantErr: "unexpected EOF"},
+	{name: "mismatched array", in: `[1}`,
+		wantErr: "invalid character '}' after array element"},
+	{name: "mismatched object", in: `{"a":1]`,
+		wantErr: "invalid character ']' after object key:value pair"},
+	{name: "mismatched $hex", in: `{"$hex":"00"]`,
+		wantErr: "invalid character ']' after object key:value pair"},
+	{name: "mismatched $dict", in: `{"$dict":[["a",1]}`,
+		wantErr: "invalid character '}' after array element"},
+	{name: "empty input", in: ``,
+		wantErr: "unexpected EOF"},
+}
+
+func TestFromJSON(t *testing.T) {
+	for _, testCase := range fromJSONTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := FromJSON([]byte(testCase.in))
+			if testCase.wantErr != "" || err != nil {
+				if err == nil {
+					t.Errorf("want error with message '%v', got no error", testCase.wantErr)
+				} else if err.Error() != testCase.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+				}
+			}
+			if string(got) != testCase.wantOutput {
+				t.Errorf("got output '%s', want '%s'", got, testCase.wantOutput)
+			}
+		})
+	}
+}
+
+func TestFromJSONDeeplyNested(t *testing.T) {
+	in := bytes.Repeat([]byte("["), DefaultMaxDepth+1)
+	if _, err := FromJSON(in); err == nil {
+		t.Errorf("want error, got no error")
+	}
+}
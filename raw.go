@@ -0,0 +1,27 @@
+package bencode
+
+import "errors"
+
+// RawMessage is a raw encoded Bencode value. It implements Marshaler and
+// Unmarshaler, so it can be used to delay decoding part of the input, or to
+// preserve its exact bytes. For example, a torrent's info-hash is computed
+// over the original encoding of its "info" dictionary, which a RawMessage
+// field captures as is.
+type RawMessage []byte
+
+// MarshalBencode returns m as the Bencode encoding of m. Like the output of
+// any Marshaler, it is checked to be a single well-formed value before it is
+// written. An empty RawMessage in a struct field or map value is left out of
+// the dictionary instead, like a nil pointer.
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return errors.New("UnmarshalBencode called on nil *RawMessage")
+	}
+	*m = append(RawMessage{}, data...)
+	return nil
+}
@@ -0,0 +1,99 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"reflect"
+	"testing"
+)
+
+type rawTorrent struct {
+	Announce string     `bencode:"announce"`
+	Info     RawMessage `bencode:"info"`
+}
+
+func TestRawMessageDecode(t *testing.T) {
+	info := "d6:lengthi10e4:name5:a.txt12:piece lengthi16384ee"
+	in := "d8:announce3:url4:info" + info + "e"
+
+	var got rawTorrent
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	want := rawTorrent{Announce: "url", Info: RawMessage(info)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got output '%+v', want '%+v'", got, want)
+	}
+	if sha1.Sum(got.Info) != sha1.Sum([]byte(info)) {
+		t.Errorf("got info-hash %x, want %x", sha1.Sum(got.Info), sha1.Sum([]byte(info)))
+	}
+}
+
+func TestRawMessageDecodeCopiesInput(t *testing.T) {
+	in := []byte("l3:abce")
+	var got RawMessage
+	if err := Unmarshal(in, &got); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	in[2] = 'X'
+	if string(got) != "l3:abce" {
+		t.Errorf("got output '%s' after modifying the input, want 'l3:abce'", got)
+	}
+}
+
+func TestRawMessageDecodeMalformed(t *testing.T) {
+	var got rawTorrent
+	err := Unmarshal([]byte("d4:infod1:xee"), &got)
+	if want := "expected start of integer, string, list, or dictionary at offset 11"; err == nil || err.Error() != want {
+		t.Errorf("got error '%v', want '%v'", err, want)
+	}
+	if got.Info != nil {
+		t.Errorf("got output '%s', want no output", got.Info)
+	}
+}
+
+var rawMessageEncodeTests = []struct {
+	name       string
+	in         interface{}
+	wantErr    string
+	wantOutput string
+}{
+	{name: "raw value", in: RawMessage("li1ei2ee"), wantOutput: "li1ei2ee"},
+	{name: "raw field",
+		in:         rawTorrent{Announce: "url", Info: RawMessage("d1:zi1e1:ai2ee")},
+		wantOutput: "d8:announce3:url4:infod1:zi1e1:ai2eee"},
+	{name: "raw list", in: []RawMessage{RawMessage("i1e"), RawMessage("0:")}, wantOutput: "li1e0:e"},
+	{name: "nil raw field is left out",
+		in:         rawTorrent{Announce: "url"},
+		wantOutput: "d8:announce3:urle"},
+	{name: "empty raw map value is left out",
+		in:         map[string]RawMessage{"a": RawMessage("i1e"), "b": RawMessage{}},
+		wantOutput: "d1:ai1ee"},
+	{name: "nil raw value", in: RawMessage(nil),
+		wantErr: "MarshalBencode for bencode.RawMessage returned invalid Bencode: no data to read at offset 0"},
+	{name: "malformed raw value", in: []RawMessage{RawMessage("l")},
+		wantErr: "MarshalBencode for *bencode.RawMessage returned invalid Bencode: expected terminator for list at offset 1"},
+	{name: "multiple raw values", in: RawMessage("i1ei2e"),
+		wantErr: "MarshalBencode for bencode.RawMessage returned invalid Bencode: trailing data at offset 3 cannot be parsed"},
+}
+
+func TestRawMessageEncode(t *testing.T) {
+	for _, testCase := range rawMessageEncodeTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			out, err := Marshal(testCase.in)
+			if testCase.wantErr != "" {
+				if err == nil {
+					t.Errorf("want error with message '%v', got no error", testCase.wantErr)
+				} else if err.Error() != testCase.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if string(out) != testCase.wantOutput {
+				t.Errorf("got output '%s', want '%s'", out, testCase.wantOutput)
+			}
+		})
+	}
+}
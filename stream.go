@@ -0,0 +1,452 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// A Decoder reads and decodes Bencode values from an input stream.
+type Decoder struct {
+	r   *bufio.Reader
+	buf bytes.Buffer
+
+	// offset is the number of bytes consumed from r so far.
+	offset int
+
+	// err is set once the stream is in a state from which decoding cannot
+	// continue, such as after a read error or malformed input. Every
+	// subsequent call to Decode returns it.
+	err error
+
+	opts DecoderOptions
+
+	// frames holds the lists and dictionaries entered with Token, innermost
+	// last.
+	frames []tokenFrame
+}
+
+// NewDecoder returns a new decoder that reads from r. The decoder buffers its
+// input and may read data from r beyond the values requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next Bencode value from its input and stores it in the
+// value pointed to by v. A stream may contain any number of concatenated
+// values; Decode returns io.EOF once all of them have been consumed.
+//
+// Decode may be mixed with calls to Token: inside a list or dictionary
+// entered with Token, it decodes the next element, key, or value.
+//
+// Offsets reported in errors are relative to the start of the stream. If the
+// stream ends in the middle of a value, Decode returns io.ErrUnexpectedEOF.
+// Input that exceeds the limits set with SetOptions is reported as soon as it
+// is read, and, like malformed input, stops the stream.
+func (dec *Decoder) Decode(v interface{}) error {
+	if dec.err != nil {
+		return dec.err
+	}
+
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	_, err := dec.next(&value)
+	return err
+}
+
+// Skip reads the next Bencode value, including everything nested in it, and
+// discards it. The value is still checked to be valid.
+func (dec *Decoder) Skip() error {
+	if dec.err != nil {
+		return dec.err
+	}
+	_, err := dec.next(nil)
+	return err
+}
+
+// next reads the next complete value and decodes it into value, or only
+// checks it if value is nil. It returns the encoding of the value.
+func (dec *Decoder) next(value *reflect.Value) ([]byte, error) {
+	if dec.expectingKey() {
+		c, err := dec.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !isDigit(c) {
+			dec.err = dec.syntaxError(dec.offset, "dictionary key at offset %d is not a string")
+			return nil, dec.err
+		}
+	}
+
+	base := dec.offset
+	data, err := dec.readValue()
+	dec.offset += len(data)
+	if err != nil {
+		if err == io.EOF && len(dec.frames) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		dec.err = err
+		return nil, err
+	}
+
+	err = unmarshal(data, base, value, dec.opts)
+	if err != nil {
+		// Malformed input leaves the stream at an unknown position, so
+		// syntax errors are sticky. Type mismatches and non-canonical
+		// encodings are not: the value has been consumed in full and
+		// decoding can resume with the next one.
+		syntaxChecker := decoder{
+			data:   data,
+			offset: 0,
+			base:   base,
+			opts:   DecoderOptions{MaxDepth: dec.opts.MaxDepth},
+		}
+		if syntaxErr := syntaxChecker.unmarshalNext(nil); syntaxErr != nil {
+			dec.err = syntaxErr
+			return nil, syntaxErr
+		}
+	}
+	if endErr := dec.endValue(data, base); endErr != nil {
+		return nil, endErr
+	}
+	return data, err
+}
+
+// TokenKind identifies the kind of a Token.
+type TokenKind int
+
+const (
+	// ListStart is the 'l' that starts a list.
+	ListStart TokenKind = iota + 1
+
+	// DictStart is the 'd' that starts a dictionary.
+	DictStart
+
+	// End is the 'e' that ends a list or dictionary.
+	End
+
+	// Int is a complete integer.
+	Int
+
+	// String is a complete string, which may be a dictionary key.
+	String
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case ListStart:
+		return "ListStart"
+	case DictStart:
+		return "DictStart"
+	case End:
+		return "End"
+	case Int:
+		return "Int"
+	case String:
+		return "String"
+	}
+	return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Token is a unit of Bencode syntax returned by Decoder.Token.
+type Token struct {
+	Kind TokenKind
+
+	// Offset is the position of the token in the stream.
+	Offset int
+
+	// Value holds the contents of a String token, and the decimal digits,
+	// including any sign, of an Int token. It is nil for other kinds.
+	Value []byte
+}
+
+// tokenFrame describes a list or dictionary that has been entered with Token
+// and not yet left.
+type tokenFrame struct {
+	kind byte
+
+	// value is set in a dictionary when a value is expected next rather
+	// than a key.
+	value bool
+
+	// prevKey is the previous key of a dictionary, which is used to check
+	// the order of keys in strict mode.
+	prevKey []byte
+}
+
+// Token returns the next token in the input stream, which lets the input be
+// walked without decoding it into Go values. Strings and integers are
+// returned whole, while lists and dictionaries are returned as a start token,
+// followed by the tokens of their elements, followed by an End token. The
+// keys and values of a dictionary alternate.
+//
+// Token checks the input as it goes, returning the same errors as Decode. At
+// the end of the input, it returns io.EOF.
+func (dec *Decoder) Token() (Token, error) {
+	if dec.err != nil {
+		return Token{}, dec.err
+	}
+
+	c, err := dec.peek()
+	if err != nil {
+		return Token{}, err
+	}
+	offset := dec.offset
+	switch {
+	case c == terminator && len(dec.frames) > 0 && !dec.frames[len(dec.frames)-1].value:
+		dec.r.ReadByte()
+		dec.offset++
+		dec.frames = dec.frames[:len(dec.frames)-1]
+		if err := dec.endValue(nil, offset); err != nil {
+			return Token{}, err
+		}
+		return Token{Kind: End, Offset: offset}, nil
+
+	case c == list || c == dictionary:
+		if dec.expectingKey() {
+			dec.err = dec.syntaxError(offset, "dictionary key at offset %d is not a string")
+			return Token{}, dec.err
+		}
+		if max := dec.opts.maxDepth(); len(dec.frames) >= max {
+			dec.err = limitError(ErrMaxDepth, max, offset)
+			return Token{}, dec.err
+		}
+		dec.r.ReadByte()
+		dec.offset++
+		dec.frames = append(dec.frames, tokenFrame{kind: c})
+		if c == list {
+			return Token{Kind: ListStart, Offset: offset}, nil
+		}
+		return Token{Kind: DictStart, Offset: offset}, nil
+	}
+
+	// Anything else is read as a whole value, which reports malformed
+	// input in the same way as Decode.
+	data, err := dec.next(nil)
+	if err != nil {
+		return Token{}, err
+	}
+	if c == integer {
+		return Token{Kind: Int, Offset: offset, Value: append([]byte{}, data[1:len(data)-1]...)}, nil
+	}
+	colon := bytes.IndexByte(data, ':')
+	return Token{Kind: String, Offset: offset, Value: append([]byte{}, data[colon+1:]...)}, nil
+}
+
+// More reports whether there is another element in the list or dictionary
+// that is being walked with Token, or, outside of any list or dictionary,
+// whether there is more input.
+func (dec *Decoder) More() bool {
+	if dec.err != nil {
+		return false
+	}
+	b, err := dec.r.Peek(1)
+	return err == nil && (len(dec.frames) == 0 || b[0] != terminator)
+}
+
+// peek returns the next byte of input without consuming it.
+func (dec *Decoder) peek() (byte, error) {
+	b, err := dec.r.Peek(1)
+	if err != nil {
+		if err == io.EOF && len(dec.frames) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		dec.err = err
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// expectingKey reports whether the next value is a dictionary key.
+func (dec *Decoder) expectingKey() bool {
+	if len(dec.frames) == 0 {
+		return false
+	}
+	frame := dec.frames[len(dec.frames)-1]
+	return frame.kind == dictionary && !frame.value
+}
+
+// endValue updates the state kept for Token once a value has been read. data
+// is the encoding of the value, which starts at offset, or nil for lists and
+// dictionaries.
+func (dec *Decoder) endValue(data []byte, offset int) error {
+	if len(dec.frames) == 0 {
+		return nil
+	}
+	frame := &dec.frames[len(dec.frames)-1]
+	if frame.kind != dictionary {
+		return nil
+	}
+	if !frame.value && dec.opts.Strict {
+		key := data[bytes.IndexByte(data, ':')+1:]
+		checker := decoder{base: offset}
+		if err := checker.checkKeyOrder(frame.prevKey, key); err != nil {
+			dec.err = err
+			return err
+		}
+		frame.prevKey = append([]byte{}, key...)
+	}
+	frame.value = !frame.value
+	return nil
+}
+
+// syntaxError returns a *SyntaxError for the given offset in the stream.
+func (dec *Decoder) syntaxError(offset int, format string) error {
+	return (&decoder{base: offset}).syntaxError(0, format)
+}
+
+// SetOptions configures how subsequent calls to Decode, Skip, and Token read
+// their input.
+func (dec *Decoder) SetOptions(opts DecoderOptions) {
+	dec.opts = opts
+}
+
+// InputOffset returns the offset in the input stream of the byte following
+// the last value returned by Decode.
+func (dec *Decoder) InputOffset() int {
+	return dec.offset
+}
+
+// readValue reads the bytes that make up the next value in the stream. Only
+// enough syntax is checked to find where the value ends: scanning stops at
+// the first byte that cannot continue the value, leaving it to the decoder to
+// report a precise error. The limits in the decoder's options are enforced as
+// the value is read, so that hostile input cannot make it buffer without
+// bound.
+func (dec *Decoder) readValue() ([]byte, error) {
+	dec.buf.Reset()
+	depth := 0
+	elements := 0
+	for {
+		c, err := dec.readByte()
+		if err != nil {
+			return dec.buf.Bytes(), err
+		}
+
+		if depth > 0 && c != terminator {
+			elements++
+			if max := dec.opts.MaxElements; max > 0 && elements > max {
+				return dec.buf.Bytes(), limitError(ErrMaxElements, max, dec.lastOffset())
+			}
+		}
+
+		ok := true
+		switch {
+		case isDigit(c):
+			ok, err = dec.readString()
+		case c == integer:
+			ok, err = dec.readInt()
+		case c == list || c == dictionary:
+			depth++
+			if max := dec.opts.maxDepth(); len(dec.frames)+depth > max {
+				return dec.buf.Bytes(), limitError(ErrMaxDepth, max, dec.lastOffset())
+			}
+		case c == terminator:
+			depth--
+		default:
+			ok = false
+		}
+		if err != nil {
+			return dec.buf.Bytes(), err
+		}
+		if !ok || depth <= 0 {
+			return dec.buf.Bytes(), nil
+		}
+	}
+}
+
+// lastOffset returns the offset in the stream of the last byte read into the
+// buffer.
+func (dec *Decoder) lastOffset() int {
+	return dec.offset + dec.buf.Len() - 1
+}
+
+// readByte reads a single byte into the buffer. Running out of input is only
+// reported as io.EOF if it happens between values.
+func (dec *Decoder) readByte() (byte, error) {
+	if max := dec.opts.MaxInputSize; max > 0 && dec.buf.Len() >= max {
+		return 0, limitError(ErrMaxInputSize, max, dec.offset+max)
+	}
+	c, err := dec.r.ReadByte()
+	if err == io.EOF && dec.buf.Len() > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	dec.buf.WriteByte(c)
+	return c, nil
+}
+
+// readString reads the remainder of a string whose first length digit has
+// already been read.
+func (dec *Decoder) readString() (bool, error) {
+	start := dec.buf.Len() - 1
+	c, err := dec.readByte()
+	for err == nil && isDigit(c) {
+		c, err = dec.readByte()
+	}
+	if err != nil || c != ':' {
+		return false, err
+	}
+
+	length, err := strconv.Atoi(string(dec.buf.Bytes()[start : dec.buf.Len()-1]))
+	if err != nil {
+		return false, nil
+	}
+	if max := dec.opts.MaxStringLength; max > 0 && length > max {
+		return false, limitError(ErrMaxStringLength, max, dec.offset+start)
+	}
+	// readByte keeps the buffer within max, so subtracting cannot overflow,
+	// unlike adding a huge length to the buffer size.
+	if max := dec.opts.MaxInputSize; max > 0 && length > max-dec.buf.Len() {
+		return false, limitError(ErrMaxInputSize, max, dec.offset+max)
+	}
+	n, err := io.CopyN(&dec.buf, dec.r, int64(length))
+	if n < int64(length) && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err == nil, err
+}
+
+// readInt reads the remainder of an integer whose leading 'i' has already
+// been read.
+func (dec *Decoder) readInt() (bool, error) {
+	c, err := dec.readByte()
+	for err == nil && (isDigit(c) || c == '-') {
+		c, err = dec.readByte()
+	}
+	if err != nil {
+		return false, err
+	}
+	return c == terminator, nil
+}
+
+// An Encoder writes Bencode values to an output stream.
+type Encoder struct {
+	out io.Writer
+	w   *bufio.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{out: w, w: bufio.NewWriter(w)}
+}
+
+// Encode writes the Bencode encoding of v to the stream. The encoding is
+// written incrementally rather than being built in memory first, so if v
+// cannot be encoded, a prefix of its encoding may already have been written.
+// Errors from the underlying writer are returned once v has been traversed.
+func (enc *Encoder) Encode(v interface{}) error {
+	if err := marshal(reflect.ValueOf(v), &encodeState{writer: enc.w}); err != nil {
+		// Drop whatever has not been written yet so that it is not flushed
+		// ahead of the next value.
+		enc.w.Reset(enc.out)
+		return err
+	}
+	return enc.w.Flush()
+}
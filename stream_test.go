@@ -0,0 +1,444 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var streamDecodeTests = []struct {
+	name        string
+	in          string
+	outputArgs  []interface{}
+	wantErr     string
+	wantOutputs []interface{}
+}{
+	{name: "empty stream", in: "",
+		wantErr: "EOF"},
+
+	{name: "single integer", in: "i651e",
+		outputArgs:  []interface{}{int64(0)},
+		wantOutputs: []interface{}{int64(651)},
+		wantErr:     "EOF"},
+
+	{name: "concatenated values", in: "i651e3:abcli1ei2eed1:xi1ee",
+		outputArgs:  []interface{}{int64(0), "", []int64{}, simpleStruct{}},
+		wantOutputs: []interface{}{int64(651), "abc", []int64{1, 2}, simpleStruct{X: 1}},
+		wantErr:     "EOF"},
+
+	{name: "nested containers", in: "d7:structsld1:xi651eeee0:",
+		outputArgs:  []interface{}{compositStruct{}, ""},
+		wantOutputs: []interface{}{compositStruct{StructList: []simpleStruct{{X: 651}}}, ""},
+		wantErr:     "EOF"},
+
+	{name: "colon inside string", in: "5:a:b:c",
+		outputArgs:  []interface{}{""},
+		wantOutputs: []interface{}{"a:b:c"},
+		wantErr:     "EOF"},
+
+	{name: "offsets relative to stream start", in: "i1ei2xe",
+		outputArgs:  []interface{}{int64(0), int64(0)},
+		wantOutputs: []interface{}{int64(1), int64(0)},
+		wantErr:     "expected terminator for integer at offset 5"},
+
+	{name: "type error offsets relative to stream start", in: "3:abcli1e3:defe",
+		outputArgs:  []interface{}{"", []int64{}},
+		wantOutputs: []interface{}{"abc", *new([]int64)},
+		wantErr:     "cannot unmarshal string at offset 9 into field [1] of type int64"},
+
+	{name: "malformed string length", in: "1:a2x3:abcde",
+		outputArgs:  []interface{}{"", ""},
+		wantOutputs: []interface{}{"a", ""},
+		wantErr:     "expected colon between length and value for string at offset 3"},
+
+	{name: "unexpected terminator", in: "i1ee",
+		outputArgs:  []interface{}{int64(0), int64(0)},
+		wantOutputs: []interface{}{int64(1), int64(0)},
+		wantErr:     "expected start of integer, string, list, or dictionary at offset 3"},
+
+	{name: "truncated integer", in: "i1ei2",
+		outputArgs:  []interface{}{int64(0), int64(0)},
+		wantOutputs: []interface{}{int64(1), int64(0)},
+		wantErr:     "unexpected EOF"},
+
+	{name: "truncated string", in: "10:abc",
+		outputArgs:  []interface{}{""},
+		wantOutputs: []interface{}{""},
+		wantErr:     "unexpected EOF"},
+
+	{name: "truncated list", in: "li1ei2e",
+		outputArgs:  []interface{}{[]int64{}},
+		wantOutputs: []interface{}{*new([]int64)},
+		wantErr:     "unexpected EOF"},
+}
+
+func TestStreamDecode(t *testing.T) {
+	for _, testCase := range streamDecodeTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			// Feed the input one byte at a time to ensure that values are
+			// pulled from the reader on demand.
+			dec := NewDecoder(iotest.OneByteReader(strings.NewReader(testCase.in)))
+			for i, outputArg := range testCase.outputArgs {
+				got := reflect.New(reflect.TypeOf(outputArg))
+				err := dec.Decode(got.Interface())
+				if i == len(testCase.outputArgs)-1 && err != nil {
+					if err.Error() != testCase.wantErr {
+						t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("value %d: got unexpected error: %v", i, err)
+				}
+				if !reflect.DeepEqual(got.Elem().Interface(), testCase.wantOutputs[i]) {
+					t.Errorf("value %d: got output '%+v', want '%+v'", i, got.Elem().Interface(), testCase.wantOutputs[i])
+				}
+			}
+
+			var extra interface{}
+			if err := dec.Decode(&extra); err == nil || err.Error() != testCase.wantErr {
+				t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamDecodeStickyErrors(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1xei2e"))
+	var i int64
+	if err := dec.Decode(&i); err == nil {
+		t.Fatalf("want error, got no error")
+	}
+	if err := dec.Decode(&i); err == nil || err.Error() != "expected terminator for integer at offset 2" {
+		t.Errorf("got error '%v', want the first error to be repeated", err)
+	}
+}
+
+func TestStreamDecodeRecoversFromTypeErrors(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("3:abci2e"))
+	var i int64
+	if err := dec.Decode(&i); err == nil {
+		t.Fatalf("want error, got no error")
+	}
+	if err := dec.Decode(&i); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if i != 2 {
+		t.Errorf("got output %d, want 2", i)
+	}
+	if got := dec.InputOffset(); got != 8 {
+		t.Errorf("got input offset %d, want 8", got)
+	}
+	if err := dec.Decode(&i); err != io.EOF {
+		t.Errorf("got error '%v', want EOF", err)
+	}
+}
+
+func TestStreamDecodeStrict(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i01ei2e"))
+	dec.SetOptions(DecoderOptions{Strict: true})
+	var i int64
+	if err := dec.Decode(&i); err == nil || err.Error() != "integer at offset 1 has a leading zero" {
+		t.Errorf("got error '%v', want 'integer at offset 1 has a leading zero'", err)
+	}
+	// The non-canonical value is consumed in full, so decoding can resume.
+	if err := dec.Decode(&i); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if i != 2 {
+		t.Errorf("got output %d, want 2", i)
+	}
+}
+
+func TestStreamDecodeLimits(t *testing.T) {
+	for _, testCase := range limitTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			// Start with another value so that offsets are checked to be
+			// relative to the stream start.
+			dec := NewDecoder(iotest.OneByteReader(strings.NewReader("0:" + testCase.in)))
+			dec.SetOptions(testCase.opts)
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			err := dec.Decode(&v)
+			if testCase.wantErr == nil {
+				if err != nil {
+					t.Errorf("got unexpected error: %v", err)
+				}
+				return
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Err != testCase.wantErr {
+				t.Fatalf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+			if limitErr.Offset != testCase.wantOffset+2 {
+				t.Errorf("got offset %d, want %d", limitErr.Offset, testCase.wantOffset+2)
+			}
+			if err := dec.Decode(&v); err != limitErr {
+				t.Errorf("got error '%v', want the first error to be repeated", err)
+			}
+		})
+	}
+}
+
+// repeatReader repeats its pattern forever.
+type repeatReader struct {
+	pattern string
+	offset  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.pattern[r.offset%len(r.pattern)]
+		r.offset++
+	}
+	return len(p), nil
+}
+
+func TestStreamDecodeLimitsUnboundedInput(t *testing.T) {
+	testCases := []struct {
+		name    string
+		r       io.Reader
+		opts    DecoderOptions
+		wantErr error
+	}{
+		{name: "endless nesting", r: &repeatReader{pattern: "l"},
+			wantErr: ErrMaxDepth},
+		{name: "endless integer", r: io.MultiReader(strings.NewReader("i"), &repeatReader{pattern: "1"}),
+			opts: DecoderOptions{MaxInputSize: 1 << 10}, wantErr: ErrMaxInputSize},
+		{name: "endless list", r: io.MultiReader(strings.NewReader("l"), &repeatReader{pattern: "0:"}),
+			opts: DecoderOptions{MaxElements: 1 << 10}, wantErr: ErrMaxElements},
+		{name: "huge string", r: io.MultiReader(strings.NewReader("1000000000:"), &repeatReader{pattern: "a"}),
+			opts: DecoderOptions{MaxStringLength: 1 << 10}, wantErr: ErrMaxStringLength},
+		{name: "huge string within input size", r: io.MultiReader(strings.NewReader("1000000000:"), &repeatReader{pattern: "a"}),
+			opts: DecoderOptions{MaxInputSize: 1 << 10}, wantErr: ErrMaxInputSize},
+		{name: "overflowing string length", r: io.MultiReader(strings.NewReader("9223372036854775807:"), &repeatReader{pattern: "a"}),
+			opts: DecoderOptions{MaxInputSize: 1 << 10}, wantErr: ErrMaxInputSize},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dec := NewDecoder(testCase.r)
+			dec.SetOptions(testCase.opts)
+			var v interface{}
+			if err := dec.Decode(&v); !errors.Is(err, testCase.wantErr) {
+				t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+// formatToken formats a token compactly, for comparison in tests.
+func formatToken(tok Token) string {
+	if tok.Value == nil {
+		return fmt.Sprintf("%v@%d", tok.Kind, tok.Offset)
+	}
+	return fmt.Sprintf("%v@%d:%s", tok.Kind, tok.Offset, tok.Value)
+}
+
+var tokenTests = []struct {
+	name       string
+	in         string
+	opts       DecoderOptions
+	wantTokens []string
+	wantErr    string
+}{
+	{name: "empty stream", in: "",
+		wantErr: "EOF"},
+	{name: "scalars", in: "i-12e3:abc0:",
+		wantTokens: []string{"Int@0:-12", "String@5:abc", "String@10:"},
+		wantErr:    "EOF"},
+	{name: "list", in: "li1e1:xe",
+		wantTokens: []string{"ListStart@0", "Int@1:1", "String@4:x", "End@7"},
+		wantErr:    "EOF"},
+	{name: "nested containers", in: "d1:ald1:bleeee",
+		wantTokens: []string{"DictStart@0", "String@1:a", "ListStart@4", "DictStart@5", "String@6:b", "ListStart@9", "End@10", "End@11", "End@12", "End@13"},
+		wantErr:    "EOF"},
+	{name: "empty containers", in: "lede",
+		wantTokens: []string{"ListStart@0", "End@1", "DictStart@2", "End@3"},
+		wantErr:    "EOF"},
+	{name: "malformed integer", in: "li1ei2xe",
+		wantTokens: []string{"ListStart@0", "Int@1:1"},
+		wantErr:    "expected terminator for integer at offset 6"},
+	{name: "unexpected terminator", in: "i1ee",
+		wantTokens: []string{"Int@0:1"},
+		wantErr:    "expected start of integer, string, list, or dictionary at offset 3"},
+	{name: "missing dictionary value", in: "d1:ae",
+		wantTokens: []string{"DictStart@0", "String@1:a"},
+		wantErr:    "expected start of integer, string, list, or dictionary at offset 4"},
+	{name: "integer key", in: "di1ei2ee",
+		wantTokens: []string{"DictStart@0"},
+		wantErr:    "dictionary key at offset 1 is not a string"},
+	{name: "list key", in: "dlei2ee",
+		wantTokens: []string{"DictStart@0"},
+		wantErr:    "dictionary key at offset 1 is not a string"},
+	{name: "truncated list", in: "li1e",
+		wantTokens: []string{"ListStart@0", "Int@1:1"},
+		wantErr:    "unexpected EOF"},
+	{name: "strict key order", in: "d1:bi1e1:ai2ee", opts: DecoderOptions{Strict: true},
+		wantTokens: []string{"DictStart@0", "String@1:b", "Int@4:1"},
+		wantErr:    "dictionary key at offset 7 is not sorted after the previous key: \"a\""},
+	{name: "strict duplicate empty keys", in: "d0:i1e0:i2ee", opts: DecoderOptions{Strict: true},
+		wantTokens: []string{"DictStart@0", "String@1:", "Int@3:1"},
+		wantErr:    "duplicate dictionary key at offset 6: \"\""},
+	{name: "strict keys in sibling dictionaries", in: "ld1:bi1eed1:ai1eee", opts: DecoderOptions{Strict: true},
+		wantTokens: []string{"ListStart@0", "DictStart@1", "String@2:b", "Int@5:1", "End@8", "DictStart@9", "String@10:a", "Int@13:1", "End@16", "End@17"},
+		wantErr:    "EOF"},
+	{name: "depth limit", in: "llleee", opts: DecoderOptions{MaxDepth: 2},
+		wantTokens: []string{"ListStart@0", "ListStart@1"},
+		wantErr:    "maximum nesting depth exceeded at offset 2"},
+}
+
+func TestStreamToken(t *testing.T) {
+	for _, testCase := range tokenTests {
+		t.Run(testCase.name, func(t *testing.T) {
+			dec := NewDecoder(iotest.OneByteReader(strings.NewReader(testCase.in)))
+			dec.SetOptions(testCase.opts)
+			var gotTokens []string
+			var err error
+			for {
+				var tok Token
+				if tok, err = dec.Token(); err != nil {
+					break
+				}
+				gotTokens = append(gotTokens, formatToken(tok))
+			}
+			if !reflect.DeepEqual(gotTokens, testCase.wantTokens) {
+				t.Errorf("got tokens %v, want %v", gotTokens, testCase.wantTokens)
+			}
+			if err.Error() != testCase.wantErr {
+				t.Errorf("got error '%v', want '%v'", err, testCase.wantErr)
+			}
+			// Errors are sticky.
+			if _, again := dec.Token(); again != err {
+				t.Errorf("got error '%v', want the first error to be repeated", again)
+			}
+		})
+	}
+}
+
+func TestStreamTokenMixedWithDecode(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d5:filesld1:xi1eed1:xi2eee4:name3:abce"))
+	var tokens []string
+	var structs []simpleStruct
+	var name string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		tokens = append(tokens, formatToken(tok))
+		switch {
+		case tok.Kind == ListStart:
+			for dec.More() {
+				var s simpleStruct
+				if err := dec.Decode(&s); err != nil {
+					t.Fatalf("got unexpected error: %v", err)
+				}
+				structs = append(structs, s)
+			}
+		case tok.Kind == String && string(tok.Value) == "name":
+			if err := dec.Decode(&name); err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+		}
+	}
+
+	wantTokens := []string{"DictStart@0", "String@1:files", "ListStart@8", "End@25", "String@26:name", "End@37"}
+	if !reflect.DeepEqual(tokens, wantTokens) {
+		t.Errorf("got tokens %v, want %v", tokens, wantTokens)
+	}
+	if want := []simpleStruct{{X: 1}, {X: 2}}; !reflect.DeepEqual(structs, want) {
+		t.Errorf("got structs %+v, want %+v", structs, want)
+	}
+	if name != "abc" {
+		t.Errorf("got name '%s', want 'abc'", name)
+	}
+}
+
+func TestStreamSkip(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d1:ald1:bi1eee1:ci2ee"))
+	for _, want := range []string{"DictStart@0", "String@1:a"} {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if got := formatToken(tok); got != want {
+			t.Errorf("got token %s, want %s", got, want)
+		}
+	}
+	if err := dec.Skip(); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if got := dec.InputOffset(); got != 14 {
+		t.Errorf("got input offset %d, want 14", got)
+	}
+	for _, want := range []string{"String@14:c", "Int@17:2", "End@20"} {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if got := formatToken(tok); got != want {
+			t.Errorf("got token %s, want %s", got, want)
+		}
+	}
+	if dec.More() {
+		t.Errorf("got more input, want none")
+	}
+}
+
+func TestStreamSkipChecksInput(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("ld1:ai1ee"))
+	if err := dec.Skip(); err == nil || err.Error() != "unexpected EOF" {
+		t.Errorf("got error '%v', want 'unexpected EOF'", err)
+	}
+}
+
+func TestStreamEncode(t *testing.T) {
+	var out strings.Builder
+	enc := NewEncoder(&out)
+	for _, in := range []interface{}{int64(651), "abc", []int64{1, 2}, compositStruct{IntList: []int64{1}}} {
+		if err := enc.Encode(in); err != nil {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+	}
+	if want := "i651e3:abcli1ei2eed4:intsli1ee7:stringsle7:structslee"; out.String() != want {
+		t.Errorf("got output '%s', want '%s'", out.String(), want)
+	}
+}
+
+func TestStreamEncodeDiscardsFailedValues(t *testing.T) {
+	var out strings.Builder
+	enc := NewEncoder(&out)
+	if err := enc.Encode([]interface{}{1, 2.5}); err == nil {
+		t.Errorf("want error, got no error")
+	}
+	if err := enc.Encode(3); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := "i3e"; out.String() != want {
+		t.Errorf("got output '%s', want '%s'", out.String(), want)
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestStreamEncodeWriteError(t *testing.T) {
+	enc := NewEncoder(failingWriter{err: io.ErrShortWrite})
+	if err := enc.Encode("abc"); err != io.ErrShortWrite {
+		t.Errorf("got error '%v', want '%v'", err, io.ErrShortWrite)
+	}
+}
@@ -0,0 +1,434 @@
+package bencode
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValueKind identifies the kind of a Value.
+type ValueKind int
+
+const (
+	// InvalidValue is the kind of the zero Value.
+	InvalidValue ValueKind = iota
+
+	IntValue
+	StringValue
+	ListValue
+	DictValue
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case InvalidValue:
+		return "invalid"
+	case IntValue:
+		return "integer"
+	case StringValue:
+		return "string"
+	case ListValue:
+		return "list"
+	case DictValue:
+		return "dictionary"
+	}
+	return "ValueKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Value holds any Bencode value: an integer, a string, a list, or a
+// dictionary. It is useful for data without a fixed schema. Values are built
+// with NewInt, NewString, NewList, NewDict, and related functions, or by
+// decoding into a Value with Unmarshal. The zero Value is invalid. Like a nil
+// pointer, it is left out of dictionaries when it is a struct field or map
+// value, and cannot be marshaled anywhere else.
+//
+// Values are immutable: accessors return copies of any data they expose.
+type Value struct {
+	kind ValueKind
+
+	// An integer is held in i, unless it does not fit in an int64, in which
+	// case it is held in bigInt.
+	i      int64
+	bigInt *big.Int
+
+	str  string
+	list []Value
+
+	// dict holds the entries of a dictionary sorted by key, without
+	// duplicates.
+	dict []DictEntry
+}
+
+// A DictEntry is a key and value in a dictionary Value.
+type DictEntry struct {
+	Key   string
+	Value Value
+}
+
+// NewInt returns an integer Value.
+func NewInt(i int64) Value {
+	return Value{kind: IntValue, i: i}
+}
+
+// NewBigInt returns an integer Value holding a copy of i, which must not be
+// nil.
+func NewBigInt(i *big.Int) Value {
+	if i.IsInt64() {
+		return NewInt(i.Int64())
+	}
+	return Value{kind: IntValue, bigInt: new(big.Int).Set(i)}
+}
+
+// NewString returns a string Value.
+func NewString(s string) Value {
+	return Value{kind: StringValue, str: s}
+}
+
+// NewBytes returns a string Value holding a copy of b.
+func NewBytes(b []byte) Value {
+	return NewString(string(b))
+}
+
+// NewList returns a list Value holding the given elements.
+func NewList(elems ...Value) Value {
+	return Value{kind: ListValue, list: append([]Value{}, elems...)}
+}
+
+// NewDict returns a dictionary Value holding the given entries. If a key
+// appears more than once, the last entry with that key is used, as when
+// decoding a dictionary into a map.
+func NewDict(entries ...DictEntry) Value {
+	dict := append([]DictEntry{}, entries...)
+	sort.SliceStable(dict, func(i, j int) bool {
+		return dict[i].Key < dict[j].Key
+	})
+	out := dict[:0]
+	for i, entry := range dict {
+		if i+1 < len(dict) && dict[i+1].Key == entry.Key {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return Value{kind: DictValue, dict: out}
+}
+
+// Entry returns a DictEntry, for use with NewDict.
+func Entry(key string, value Value) DictEntry {
+	return DictEntry{Key: key, Value: value}
+}
+
+// Kind returns the kind of v.
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+// AsInt returns the integer held by v. It reports false if v is not an
+// integer or does not fit in an int64.
+func (v Value) AsInt() (int64, bool) {
+	if v.kind != IntValue || v.bigInt != nil {
+		return 0, false
+	}
+	return v.i, true
+}
+
+// AsBigInt returns a copy of the integer held by v. It reports false if v is
+// not an integer.
+func (v Value) AsBigInt() (*big.Int, bool) {
+	if v.kind != IntValue {
+		return nil, false
+	}
+	if v.bigInt != nil {
+		return new(big.Int).Set(v.bigInt), true
+	}
+	return big.NewInt(v.i), true
+}
+
+// AsBytes returns a copy of the string held by v. It reports false if v is
+// not a string.
+func (v Value) AsBytes() ([]byte, bool) {
+	if v.kind != StringValue {
+		return nil, false
+	}
+	return []byte(v.str), true
+}
+
+// AsString returns the string held by v. It reports false if v is not a
+// string.
+func (v Value) AsString() (string, bool) {
+	if v.kind != StringValue {
+		return "", false
+	}
+	return v.str, true
+}
+
+// Len returns the number of elements in a list, the number of entries in a
+// dictionary, or the length of a string. It returns zero for other kinds.
+func (v Value) Len() int {
+	switch v.kind {
+	case StringValue:
+		return len(v.str)
+	case ListValue:
+		return len(v.list)
+	case DictValue:
+		return len(v.dict)
+	}
+	return 0
+}
+
+// Index returns the element of a list at index i. It reports false if v is
+// not a list or i is out of range.
+func (v Value) Index(i int) (Value, bool) {
+	if v.kind != ListValue || i < 0 || i >= len(v.list) {
+		return Value{}, false
+	}
+	return v.list[i], true
+}
+
+// Key returns the value stored under key in a dictionary. It reports false if
+// v is not a dictionary or has no such key.
+func (v Value) Key(key string) (Value, bool) {
+	if v.kind != DictValue {
+		return Value{}, false
+	}
+	i := sort.Search(len(v.dict), func(i int) bool {
+		return v.dict[i].Key >= key
+	})
+	if i == len(v.dict) || v.dict[i].Key != key {
+		return Value{}, false
+	}
+	return v.dict[i].Value, true
+}
+
+// List returns a copy of the elements of a list, or nil if v is not a list.
+func (v Value) List() []Value {
+	if v.kind != ListValue {
+		return nil
+	}
+	return append([]Value{}, v.list...)
+}
+
+// Entries returns a copy of the entries of a dictionary sorted by key, or nil
+// if v is not a dictionary.
+func (v Value) Entries() []DictEntry {
+	if v.kind != DictValue {
+		return nil
+	}
+	return append([]DictEntry{}, v.dict...)
+}
+
+// Equal reports whether v and other hold the same Bencode value.
+func (v Value) Equal(other Value) bool {
+	if v.kind != other.kind {
+		return false
+	}
+	switch v.kind {
+	case IntValue:
+		if v.bigInt != nil || other.bigInt != nil {
+			return v.bigInt != nil && other.bigInt != nil && v.bigInt.Cmp(other.bigInt) == 0
+		}
+		return v.i == other.i
+	case StringValue:
+		return v.str == other.str
+	case ListValue:
+		if len(v.list) != len(other.list) {
+			return false
+		}
+		for i := range v.list {
+			if !v.list[i].Equal(other.list[i]) {
+				return false
+			}
+		}
+	case DictValue:
+		if len(v.dict) != len(other.dict) {
+			return false
+		}
+		for i := range v.dict {
+			if v.dict[i].Key != other.dict[i].Key || !v.dict[i].Value.Equal(other.dict[i].Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns a human-readable form of v, which is the same for all equal
+// values. Integers are written in decimal, strings are quoted as Go string
+// literals, lists are written as [a, b], and dictionaries as {"k": v} with
+// their keys in order.
+func (v Value) String() string {
+	var b strings.Builder
+	v.format(&b)
+	return b.String()
+}
+
+func (v Value) format(b *strings.Builder) {
+	switch v.kind {
+	case IntValue:
+		if v.bigInt != nil {
+			b.WriteString(v.bigInt.String())
+		} else {
+			b.WriteString(strconv.FormatInt(v.i, 10))
+		}
+	case StringValue:
+		b.WriteString(strconv.Quote(v.str))
+	case ListValue:
+		b.WriteByte('[')
+		for i, elem := range v.list {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			elem.format(b)
+		}
+		b.WriteByte(']')
+	case DictValue:
+		b.WriteByte('{')
+		for i, entry := range v.dict {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(entry.Key))
+			b.WriteString(": ")
+			entry.Value.format(b)
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString("<invalid>")
+	}
+}
+
+// MarshalBencode returns the canonical Bencode encoding of v.
+func (v Value) MarshalBencode() ([]byte, error) {
+	var buf strings.Builder
+	if err := v.marshal(&buf); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+func (v Value) marshal(buf writer) error {
+	switch v.kind {
+	case IntValue:
+		if v.bigInt != nil {
+			marshalBigInt(v.bigInt, buf)
+		} else {
+			marshalInt(v.i, buf)
+		}
+	case StringValue:
+		marshalString(v.str, buf)
+	case ListValue:
+		buf.WriteByte('l')
+		for _, elem := range v.list {
+			if err := elem.marshal(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case DictValue:
+		buf.WriteByte('d')
+		for _, entry := range v.dict {
+			marshalString(entry.Key, buf)
+			if err := entry.Value.marshal(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return errors.New("cannot marshal invalid Value")
+	}
+	return nil
+}
+
+// UnmarshalBencode sets *v to the value encoded in data.
+func (v *Value) UnmarshalBencode(data []byte) error {
+	if v == nil {
+		return errors.New("UnmarshalBencode called on nil *Value")
+	}
+	return Unmarshal(data, v)
+}
+
+// unmarshalValue decodes the next value into the Value that value points to.
+// Values are decoded here rather than through UnmarshalBencode, so that the
+// decoder's options apply to them.
+func (d *decoder) unmarshalValue(value *reflect.Value) error {
+	var b valueBuilder
+	if err := d.walk(&b); err != nil {
+		return err
+	}
+	d.valueSetter.Set(value, reflect.ValueOf(b.result))
+	return nil
+}
+
+// valueBuilder is a visitor that builds a Value.
+type valueBuilder struct {
+	// containers holds the lists and dictionaries that enclose the current
+	// value.
+	containers []valueContainer
+	result     Value
+}
+
+// valueContainer is a list or dictionary being built by valueBuilder.
+type valueContainer struct {
+	isDict  bool
+	list    []Value
+	entries []DictEntry
+	key     string
+}
+
+// add adds a complete value to the enclosing container, or makes it the
+// result if it is the top-level value.
+func (b *valueBuilder) add(v Value) error {
+	if len(b.containers) == 0 {
+		b.result = v
+		return nil
+	}
+	c := &b.containers[len(b.containers)-1]
+	if c.isDict {
+		c.entries = append(c.entries, DictEntry{Key: c.key, Value: v})
+	} else {
+		c.list = append(c.list, v)
+	}
+	return nil
+}
+
+// pop removes the innermost container.
+func (b *valueBuilder) pop() valueContainer {
+	c := b.containers[len(b.containers)-1]
+	b.containers = b.containers[:len(b.containers)-1]
+	return c
+}
+
+func (b *valueBuilder) Int(digits []byte) error {
+	// The digits have been checked, so they always parse.
+	i, _ := new(big.Int).SetString(string(digits), 10)
+	return b.add(NewBigInt(i))
+}
+
+func (b *valueBuilder) String(length, s []byte) error {
+	return b.add(NewBytes(s))
+}
+
+func (b *valueBuilder) ListStart() error {
+	b.containers = append(b.containers, valueContainer{})
+	return nil
+}
+
+func (b *valueBuilder) ListEnd() error {
+	return b.add(Value{kind: ListValue, list: b.pop().list})
+}
+
+func (b *valueBuilder) DictStart() error {
+	b.containers = append(b.containers, valueContainer{isDict: true})
+	return nil
+}
+
+func (b *valueBuilder) Key(length, key []byte, offset int) error {
+	b.containers[len(b.containers)-1].key = string(key)
+	return nil
+}
+
+func (b *valueBuilder) DictEnd() error {
+	return b.add(NewDict(b.pop().entries...))
+}
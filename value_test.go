@@ -0,0 +1,245 @@
+package bencode
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValueAccessors(t *testing.T) {
+	v := NewDict(
+		Entry("name", NewString("abc")),
+		Entry("length", NewInt(-10)),
+		Entry("pieces", NewBytes([]byte{0xff, 0x00})),
+		Entry("files", NewList(NewInt(1), NewList())),
+	)
+	if v.Kind() != DictValue || v.Len() != 4 {
+		t.Errorf("got kind %v and length %d, want dictionary of length 4", v.Kind(), v.Len())
+	}
+	if name, ok := v.Key("name"); !ok || name.Kind() != StringValue {
+		t.Errorf("got key 'name' %v, %t, want a string", name, ok)
+	} else if s, _ := name.AsString(); s != "abc" {
+		t.Errorf("got name '%s', want 'abc'", s)
+	}
+	length, _ := v.Key("length")
+	if i, ok := length.AsInt(); !ok || i != -10 {
+		t.Errorf("got length %d, %t, want -10", i, ok)
+	}
+	if _, ok := length.AsBytes(); ok {
+		t.Errorf("got bytes from an integer, want none")
+	}
+	pieces, _ := v.Key("pieces")
+	if b, ok := pieces.AsBytes(); !ok || !reflect.DeepEqual(b, []byte{0xff, 0x00}) {
+		t.Errorf("got pieces %x, %t, want ff00", b, ok)
+	}
+	files, _ := v.Key("files")
+	if elem, ok := files.Index(1); !ok || elem.Kind() != ListValue || elem.Len() != 0 {
+		t.Errorf("got element %v, %t, want an empty list", elem, ok)
+	}
+	for _, i := range []int{-1, 2} {
+		if _, ok := files.Index(i); ok {
+			t.Errorf("got element at index %d, want none", i)
+		}
+	}
+	if _, ok := v.Key("missing"); ok {
+		t.Errorf("got missing key, want none")
+	}
+	if _, ok := files.Key("name"); ok {
+		t.Errorf("got key of a list, want none")
+	}
+	var keys []string
+	for _, entry := range v.Entries() {
+		keys = append(keys, entry.Key)
+	}
+	if want := []string{"files", "length", "name", "pieces"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+}
+
+func TestValueBigInt(t *testing.T) {
+	huge := new(big.Int).Neg(hugeInt)
+	v := NewBigInt(huge)
+	if _, ok := v.AsInt(); ok {
+		t.Errorf("got an int64 from a huge integer, want none")
+	}
+	if got, ok := v.AsBigInt(); !ok || got.Cmp(huge) != 0 {
+		t.Errorf("got big integer %v, %t, want %v", got, ok, huge)
+	}
+	if !NewBigInt(big.NewInt(5)).Equal(NewInt(5)) {
+		t.Errorf("got big integer 5 not equal to integer 5")
+	}
+}
+
+func TestValueNewDictDuplicateKeys(t *testing.T) {
+	v := NewDict(Entry("a", NewInt(1)), Entry("b", NewInt(2)), Entry("a", NewInt(3)))
+	if want := `{"a": 3, "b": 2}`; v.String() != want {
+		t.Errorf("got '%s', want '%s'", v.String(), want)
+	}
+}
+
+func TestValueEqual(t *testing.T) {
+	values := []Value{
+		{},
+		NewInt(1),
+		NewInt(2),
+		NewString("1"),
+		NewString(""),
+		NewList(),
+		NewList(NewInt(1)),
+		NewList(NewInt(1), NewInt(2)),
+		NewDict(),
+		NewDict(Entry("a", NewInt(1))),
+		NewDict(Entry("a", NewInt(2))),
+		NewDict(Entry("b", NewInt(1))),
+	}
+	for i, a := range values {
+		for j, b := range values {
+			if got := a.Equal(b); got != (i == j) {
+				t.Errorf("got %v.Equal(%v) = %t, want %t", a, b, got, i == j)
+			}
+		}
+	}
+	ordered := NewDict(Entry("a", NewInt(1)), Entry("b", NewList()))
+	reordered := NewDict(Entry("b", NewList()), Entry("a", NewInt(1)))
+	if !ordered.Equal(reordered) {
+		t.Errorf("got %v not equal to %v", ordered, reordered)
+	}
+}
+
+func TestValueString(t *testing.T) {
+	v := NewList(NewInt(-1), NewString("a\"b"), NewBytes([]byte{0xff}), NewDict(Entry("z", NewList()), Entry("a", NewDict())))
+	if want := `[-1, "a\"b", "\xff", {"a": {}, "z": []}]`; v.String() != want {
+		t.Errorf("got '%s', want '%s'", v.String(), want)
+	}
+	if want := "<invalid>"; (Value{}).String() != want {
+		t.Errorf("got '%s', want '%s'", Value{}.String(), want)
+	}
+}
+
+var hugeInt, _ = new(big.Int).SetString("123456789012345678901234567890", 10)
+
+type valueStruct struct {
+	Name     string `bencode:"name"`
+	Extra    Value  `bencode:"extra"`
+	Optional Value  `bencode:"optional,omitempty"`
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want Value
+	}{
+		{name: "integer", in: "i-5e", want: NewInt(-5)},
+		{name: "huge integer", in: "i123456789012345678901234567890e",
+			want: NewBigInt(hugeInt)},
+		{name: "string", in: "3:a\x00b", want: NewString("a\x00b")},
+		{name: "list", in: "li1e0:lee", want: NewList(NewInt(1), NewString(""), NewList())},
+		{name: "dictionary", in: "d1:ai1e1:bd1:cleee",
+			want: NewDict(Entry("a", NewInt(1)), Entry("b", NewDict(Entry("c", NewList()))))},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var got Value
+			if err := Unmarshal([]byte(testCase.in), &got); err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if !got.Equal(testCase.want) {
+				t.Errorf("got %v, want %v", got, testCase.want)
+			}
+			out, err := Marshal(got)
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if string(out) != testCase.in {
+				t.Errorf("got output '%s', want '%s'", out, testCase.in)
+			}
+		})
+	}
+}
+
+func TestValueCanonicalizesOnMarshal(t *testing.T) {
+	var v Value
+	if err := Unmarshal([]byte("d1:bi01e1:ai1e1:bi2ee"), &v); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := "d1:ai1e1:bi2ee"; string(out) != want {
+		t.Errorf("got output '%s', want '%s'", out, want)
+	}
+}
+
+func TestValueInStruct(t *testing.T) {
+	var got valueStruct
+	if err := Unmarshal([]byte("d5:extrali1ei2ee4:name3:abce"), &got); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := NewList(NewInt(1), NewInt(2)); got.Name != "abc" || !got.Extra.Equal(want) {
+		t.Errorf("got output '%+v', want extra %v", got, want)
+	}
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := "d5:extrali1ei2ee4:name3:abce"; string(out) != want {
+		t.Errorf("got output '%s', want '%s'", out, want)
+	}
+
+	got.Optional = NewInt(0)
+	out, err = Marshal(got)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := "d5:extrali1ei2ee4:name3:abc8:optionali0ee"; string(out) != want {
+		t.Errorf("got output '%s', want '%s'", out, want)
+	}
+	// An absent Value is left out when marshaling, like a nil pointer, so
+	// decoding and encoding again round-trips.
+	var absent valueStruct
+	if err := Unmarshal([]byte("d4:name3:abce"), &absent); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	out, err = Marshal(absent)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := "d4:name3:abce"; string(out) != want {
+		t.Errorf("got output '%s', want '%s'", out, want)
+	}
+
+	out, err = Marshal(map[string]Value{"a": {}, "b": NewInt(1)})
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if want := "d1:bi1ee"; string(out) != want {
+		t.Errorf("got output '%s', want '%s'", out, want)
+	}
+}
+
+func TestValueInStructUsesOptions(t *testing.T) {
+	depth := DefaultMaxDepth + 1
+	in := "d5:extra" + strings.Repeat("l", depth) + strings.Repeat("e", depth) + "4:name3:abce"
+	var got valueStruct
+	if err := (DecoderOptions{MaxDepth: -1}).Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if got.Extra.Kind() != ListValue {
+		t.Errorf("got kind %v, want %v", got.Extra.Kind(), ListValue)
+	}
+
+	err := (DecoderOptions{MaxElements: 3}).Unmarshal([]byte("d5:extrali1ei2ee4:name3:abce"), &got)
+	if !errors.Is(err, ErrMaxElements) {
+		t.Errorf("got error '%v', want '%v'", err, ErrMaxElements)
+	}
+}
+
+func TestValueMarshalInvalid(t *testing.T) {
+	if _, err := Marshal(Value{}); err == nil {
+		t.Errorf("want error, got no error")
+	}
+}
@@ -0,0 +1,126 @@
+package bencode
+
+// A visitor receives the values that decoder.walk finds, in input order.
+// Returning an error from any method stops the walk.
+type visitor interface {
+	// Int is called with the text of an integer, between the 'i' and 'e'.
+	Int(digits []byte) error
+
+	// String is called with a string that is not a dictionary key, and with
+	// its length as written in the input.
+	String(length, s []byte) error
+
+	ListStart() error
+	ListEnd() error
+
+	// DictStart is called at the start of a dictionary, and Key at the start
+	// of each entry, before the entry's value is walked. offset is the
+	// position of the key in the input.
+	DictStart() error
+	Key(length, key []byte, offset int) error
+	DictEnd() error
+}
+
+// walk reads the next value and reports its contents to v. It checks the
+// input against the decoder's options like unmarshalNext does, but leaves
+// what to build from it to v.
+func (d *decoder) walk(v visitor) error {
+	if d.isDone() {
+		return d.syntaxError(d.offset, "no data to read at offset %d")
+	}
+
+	switch c := d.data[d.offset]; {
+	case isDigit(c):
+		start, limit, err := d.stringIndices(d.offset)
+		if err != nil {
+			return err
+		}
+		length := d.data[d.offset : start-1]
+		d.offset = limit
+		return v.String(length, d.data[start:limit])
+
+	case c == integer:
+		start := d.offset
+		if err := d.unmarshalInt(nil); err != nil {
+			return err
+		}
+		return v.Int(d.data[start+1 : d.offset-1])
+
+	case c == list:
+		return d.walkList(v)
+
+	case c == dictionary:
+		return d.walkDict(v)
+	}
+	return d.syntaxError(d.offset, "expected start of integer, string, list, or dictionary at offset %d")
+}
+
+func (d *decoder) walkList(v visitor) error {
+	if err := d.enterContainer(); err != nil {
+		return err
+	}
+	d.offset++ // Consume 'l'.
+	if err := v.ListStart(); err != nil {
+		return err
+	}
+	for d.offset < len(d.data) && d.data[d.offset] != terminator {
+		if err := d.countElement(); err != nil {
+			return err
+		}
+		if err := d.walk(v); err != nil {
+			return err
+		}
+	}
+	if d.offset >= len(d.data) {
+		return d.syntaxError(d.offset, "expected terminator for list at offset %d")
+	}
+	d.offset++
+	d.exitContainer()
+	return v.ListEnd()
+}
+
+func (d *decoder) walkDict(v visitor) error {
+	if err := d.enterContainer(); err != nil {
+		return err
+	}
+	d.offset++ // Consume 'd'.
+	if err := v.DictStart(); err != nil {
+		return err
+	}
+	var prevKey []byte
+	for d.offset < len(d.data) && d.data[d.offset] != terminator {
+		if err := d.countElement(); err != nil {
+			return err
+		}
+		if !isDigit(d.data[d.offset]) {
+			return d.syntaxError(d.offset, "dictionary key at offset %d is not a string")
+		}
+		start, limit, err := d.stringIndices(d.offset)
+		if err != nil {
+			return err
+		}
+		key := d.data[start:limit]
+		if d.opts.Strict {
+			if err := d.checkKeyOrder(prevKey, key); err != nil {
+				return err
+			}
+			prevKey = key
+		}
+		if err := v.Key(d.data[d.offset:start-1], key, d.offset); err != nil {
+			return err
+		}
+		d.offset = limit
+		if err := d.countElement(); err != nil {
+			return err
+		}
+		if err := d.walk(v); err != nil {
+			return err
+		}
+	}
+	if d.offset >= len(d.data) {
+		return d.syntaxError(d.offset, "expected terminator for dictionary at offset %d")
+	}
+	d.offset++
+	d.exitContainer()
+	return v.DictEnd()
+}